@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// AUDIT LOG - One JSON line per admin change
+// ============================================================================
+
+type AuditEntry struct {
+	Time   time.Time   `json:"time"`
+	Remote string      `json:"remote"`
+	Action string      `json:"action"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type AuditLog struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewAuditLog appends to path, or writes to stderr if path is empty.
+func NewAuditLog(path string) (*AuditLog, error) {
+	if path == "" {
+		return &AuditLog{out: os.Stderr}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	return &AuditLog{out: f}, nil
+}
+
+func (a *AuditLog) Record(entry AuditEntry) {
+	entry.Time = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Audit log: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.out.Write(append(data, '\n')); err != nil {
+		log.Printf("Audit log: %v", err)
+	}
+}
+
+// ============================================================================
+// ADMIN API - Live tuning of workers, concurrency and cache
+// ============================================================================
+
+// registerAdmin mounts /admin/* when an admin token is configured.
+func (s *Server) registerAdmin() {
+	if s.adminToken == "" {
+		log.Println("ADMIN_TOKEN not set, admin API disabled")
+		return
+	}
+
+	http.HandleFunc("/admin/config", s.requireAdmin(s.handleAdminConfig))
+	http.HandleFunc("/admin/workers", s.requireAdmin(s.handleAdminWorkers))
+	http.HandleFunc("/admin/rate-limit", s.requireAdmin(s.handleAdminRateLimit))
+	http.HandleFunc("/admin/cache/flush", s.requireAdmin(s.handleAdminCacheFlush))
+	http.HandleFunc("/admin/cache/resize", s.requireAdmin(s.handleAdminCacheResize))
+}
+
+// requireAdmin checks the bearer token. Admin requests bypass the request
+// semaphore so the server can still be tuned while saturated.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			s.analytics.RecordError("admin_unauthorized")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) adminConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"worker_count":      s.taskProcessor.WorkerCount(),
+		"max_concurrent":    s.rateLimiter.MaxConcurrent(),
+		"cache_max_entries": s.cache.MaxEntries(),
+		"cache_entries":     s.cache.Len(),
+		"queue_depth":       s.taskProcessor.queue.Len(),
+	}
+}
+
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.adminConfig())
+}
+
+// adminChange decodes a POST body into req, applies it and audits the
+// before/after values whether or not it succeeded.
+func (s *Server) adminChange(w http.ResponseWriter, r *http.Request, action string, req interface{}, apply func() (before, after interface{}, err error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req != nil {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	before, after, err := apply()
+
+	entry := AuditEntry{Remote: r.RemoteAddr, Action: action, Before: before, After: after}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	s.audit.Record(entry)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"action": action,
+		"before": before,
+		"after":  after,
+	})
+}
+
+func (s *Server) handleAdminWorkers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Count int `json:"count"`
+	}
+	s.adminChange(w, r, "workers.resize", &req, func() (interface{}, interface{}, error) {
+		before := s.taskProcessor.WorkerCount()
+		if err := s.taskProcessor.Resize(req.Count); err != nil {
+			return before, req.Count, err
+		}
+		return before, s.taskProcessor.WorkerCount(), nil
+	})
+}
+
+func (s *Server) handleAdminRateLimit(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MaxConcurrent int `json:"max_concurrent"`
+	}
+	s.adminChange(w, r, "rate_limit.resize", &req, func() (interface{}, interface{}, error) {
+		before := s.rateLimiter.MaxConcurrent()
+		if err := s.rateLimiter.SetMaxConcurrent(req.MaxConcurrent); err != nil {
+			return before, req.MaxConcurrent, err
+		}
+		return before, s.rateLimiter.MaxConcurrent(), nil
+	})
+}
+
+func (s *Server) handleAdminCacheFlush(w http.ResponseWriter, r *http.Request) {
+	s.adminChange(w, r, "cache.flush", nil, func() (interface{}, interface{}, error) {
+		flushed := s.cache.Flush()
+		return map[string]int{"entries": flushed}, map[string]int{"entries": 0}, nil
+	})
+}
+
+func (s *Server) handleAdminCacheResize(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MaxEntries int `json:"max_entries"`
+	}
+	s.adminChange(w, r, "cache.resize", &req, func() (interface{}, interface{}, error) {
+		before := map[string]int{"max_entries": s.cache.MaxEntries(), "entries": s.cache.Len()}
+		evicted, err := s.cache.Resize(req.MaxEntries)
+		if err != nil {
+			return before, nil, err
+		}
+		after := map[string]int{"max_entries": s.cache.MaxEntries(), "entries": s.cache.Len(), "evicted": evicted}
+		return before, after, nil
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminRequiresBearerToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string // server side
+		header     string
+		wantStatus int
+	}{
+		{name: "valid token", token: "secret", header: "Bearer secret", wantStatus: http.StatusOK},
+		{name: "no header", token: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", header: "Bearer guess", wantStatus: http.StatusUnauthorized},
+		{name: "token without scheme", token: "secret", header: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "other scheme", token: "secret", header: "Basic secret", wantStatus: http.StatusUnauthorized},
+		{name: "prefix of token", token: "secret", header: "Bearer secre", wantStatus: http.StatusUnauthorized},
+		{name: "empty server token", token: "", header: "Bearer ", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var audit bytes.Buffer
+			s := &Server{
+				analytics:  NewAnalytics(),
+				cache:      NewCache(),
+				adminToken: tt.token,
+				audit:      &AuditLog{out: &audit},
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/cache/flush", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			s.requireAdmin(s.handleAdminCacheFlush)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			// Only an authorised change reaches the handler and the audit log
+			audited := strings.Contains(audit.String(), `"action":"cache.flush"`)
+			if audited != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("audited = %v for status %d", audited, rec.Code)
+			}
+			if tt.wantStatus == http.StatusUnauthorized {
+				errs := s.analytics.GetStats()["error_count"].(map[string]int64)
+				if errs["admin_unauthorized"] != 1 {
+					t.Errorf("error counts %v, want one admin_unauthorized", errs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================================================
+// LOAD TEST SCENARIOS - Declarative virtual users and request mix
+// ============================================================================
+
+// Duration wraps time.Duration so scenario files can say "30s" or "250ms".
+type Duration time.Duration
+
+func (d Duration) Std() time.Duration { return time.Duration(d) }
+
+func (d *Duration) parse(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		return d.parse(s)
+	}
+	var ms int64
+	if err := json.Unmarshal(b, &ms); err != nil {
+		return fmt.Errorf("duration must be a string or milliseconds: %s", b)
+	}
+	*d = Duration(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var ms int64
+	if err := node.Decode(&ms); err == nil {
+		*d = Duration(time.Duration(ms) * time.Millisecond)
+		return nil
+	}
+	return d.parse(node.Value)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ThinkTime is the pause a virtual user takes between requests,
+// picked uniformly from [Min, Max].
+type ThinkTime struct {
+	Min Duration `json:"min" yaml:"min"`
+	Max Duration `json:"max" yaml:"max"`
+}
+
+func (t ThinkTime) next() time.Duration {
+	lo, hi := t.Min.Std(), t.Max.Std()
+	if hi <= lo {
+		return lo
+	}
+	return lo + time.Duration(rand.Int63n(int64(hi-lo)))
+}
+
+// RequestTemplate describes one kind of request a virtual user can make.
+// Path and Body are Go text/templates rendered per request with
+// {{.VU}}, {{.Iteration}} and the helpers randInt / randChoice. Body may
+// be a string or a JSON/YAML object; objects are marshalled to JSON first,
+// so actions needing quoted arguments should use the string form.
+// ExpectStatus overrides the scenario's success codes for this request.
+type RequestTemplate struct {
+	Name         string            `json:"name" yaml:"name"`
+	Method       string            `json:"method" yaml:"method"`
+	Path         string            `json:"path" yaml:"path"`
+	Headers      map[string]string `json:"headers,omitempty" yaml:"headers"`
+	Body         interface{}       `json:"body,omitempty" yaml:"body"`
+	Weight       int               `json:"weight" yaml:"weight"`
+	ExpectStatus []string          `json:"expect_status,omitempty" yaml:"expect_status"`
+
+	pathTmpl *template.Template
+	bodyTmpl *template.Template
+	expect   func(code int) bool
+}
+
+// Scenario is a complete load test definition.
+type Scenario struct {
+	Name           string            `json:"name" yaml:"name"`
+	VirtualUsers   int               `json:"virtual_users" yaml:"virtual_users"`
+	RampUp         Duration          `json:"ramp_up" yaml:"ramp_up"`
+	Duration       Duration          `json:"duration" yaml:"duration"`
+	ThinkTime      ThinkTime         `json:"think_time" yaml:"think_time"`
+	RequestTimeout Duration          `json:"request_timeout" yaml:"request_timeout"`
+	ReportInterval Duration          `json:"report_interval" yaml:"report_interval"`
+	ExpectStatus   []string          `json:"expect_status,omitempty" yaml:"expect_status"`
+	Requests       []RequestTemplate `json:"requests" yaml:"requests"`
+
+	totalWeight int
+}
+
+var templateFuncs = template.FuncMap{
+	"randInt": func(n int) int { return rand.Intn(n) },
+	"randChoice": func(items ...string) string {
+		return items[rand.Intn(len(items))]
+	},
+}
+
+// defaultExpectStatus counts any response below 400 as a success.
+var defaultExpectStatus = []string{"2xx", "3xx"}
+
+// parseExpectStatus compiles status entries such as "200" or "4xx" into
+// a predicate on the response code.
+func parseExpectStatus(entries []string) (func(code int) bool, error) {
+	exact := make(map[int]bool)
+	classes := make(map[int]bool)
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if len(e) == 3 && strings.HasSuffix(e, "xx") && e[0] >= '1' && e[0] <= '5' {
+			classes[int(e[0]-'0')] = true
+			continue
+		}
+		code, err := strconv.Atoi(e)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid expected status %q", e)
+		}
+		exact[code] = true
+	}
+	return func(code int) bool { return exact[code] || classes[code/100] }, nil
+}
+
+// DefaultScenario mirrors the original hard-coded load test: 200 clients
+// spread evenly over process, cached-data and stats.
+func DefaultScenario(duration time.Duration) *Scenario {
+	s := &Scenario{
+		Name:           "default",
+		VirtualUsers:   200,
+		Duration:       Duration(duration),
+		ThinkTime:      ThinkTime{Min: Duration(10 * time.Millisecond), Max: Duration(30 * time.Millisecond)},
+		RequestTimeout: Duration(10 * time.Second),
+		ReportInterval: Duration(2 * time.Second),
+		Requests: []RequestTemplate{
+			{
+				Name:   "process-compute",
+				Method: http.MethodPost,
+				Path:   "/api/process",
+				Body:   `{"task_type":"compute","payload":{"value":{{randInt 1000}}}}`,
+				Weight: 1,
+			},
+			{
+				Name:   "process-database",
+				Method: http.MethodPost,
+				Path:   "/api/process",
+				Body:   `{"task_type":"database","payload":{"table":"orders","limit":{{randInt 100}}}}`,
+				Weight: 1,
+			},
+			{
+				Name:   "process-external",
+				Method: http.MethodPost,
+				Path:   "/api/process",
+				Body:   `{"task_type":"external_api","payload":{"endpoint":"/v1/status"}}`,
+				Weight: 1,
+			},
+			{Name: "cached-data", Method: http.MethodGet, Path: "/api/cached-data?key=key_{{randInt 10}}", Weight: 3},
+			{Name: "stats", Method: http.MethodGet, Path: "/api/stats", Weight: 3},
+		},
+	}
+	if err := s.prepare(); err != nil {
+		panic(err) // built-in scenario is static; a failure here is a programming error
+	}
+	return s
+}
+
+// LoadScenario reads a scenario from a .json, .yaml or .yml file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read scenario: %w", err)
+	}
+
+	var s Scenario
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &s)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &s)
+	default:
+		return nil, fmt.Errorf("unsupported scenario format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse scenario %s: %w", path, err)
+	}
+
+	if err := s.prepare(); err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// prepare applies defaults, validates the scenario and compiles templates.
+func (s *Scenario) prepare() error {
+	if s.VirtualUsers <= 0 {
+		return fmt.Errorf("virtual_users must be positive")
+	}
+	if s.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if len(s.Requests) == 0 {
+		return fmt.Errorf("at least one request is required")
+	}
+	if s.RequestTimeout <= 0 {
+		s.RequestTimeout = Duration(10 * time.Second)
+	}
+	if s.ReportInterval <= 0 {
+		s.ReportInterval = Duration(2 * time.Second)
+	}
+
+	if len(s.ExpectStatus) == 0 {
+		s.ExpectStatus = defaultExpectStatus
+	}
+	if _, err := parseExpectStatus(s.ExpectStatus); err != nil {
+		return fmt.Errorf("expect_status: %w", err)
+	}
+
+	s.totalWeight = 0
+	for i := range s.Requests {
+		req := &s.Requests[i]
+		if req.Path == "" {
+			return fmt.Errorf("request %d: path is required", i)
+		}
+		if req.Method == "" {
+			req.Method = http.MethodGet
+		}
+		req.Method = strings.ToUpper(req.Method)
+		if req.Name == "" {
+			req.Name = req.Method + " " + req.Path
+		}
+		if req.Weight <= 0 {
+			req.Weight = 1
+		}
+		s.totalWeight += req.Weight
+
+		expect := req.ExpectStatus
+		if len(expect) == 0 {
+			expect = s.ExpectStatus
+		}
+		var err error
+		if req.expect, err = parseExpectStatus(expect); err != nil {
+			return fmt.Errorf("request %s: expect_status: %w", req.Name, err)
+		}
+
+		if req.pathTmpl, err = template.New(req.Name + ".path").Funcs(templateFuncs).Parse(req.Path); err != nil {
+			return fmt.Errorf("request %s: path template: %w", req.Name, err)
+		}
+
+		if req.Body == nil {
+			continue
+		}
+		bodySrc, ok := req.Body.(string)
+		if !ok {
+			raw, err := json.Marshal(req.Body)
+			if err != nil {
+				return fmt.Errorf("request %s: body: %w", req.Name, err)
+			}
+			bodySrc = string(raw)
+		}
+		if req.bodyTmpl, err = template.New(req.Name + ".body").Funcs(templateFuncs).Parse(bodySrc); err != nil {
+			return fmt.Errorf("request %s: body template: %w", req.Name, err)
+		}
+	}
+	return nil
+}
+
+// pick selects a request template according to the configured weights.
+func (s *Scenario) pick() *RequestTemplate {
+	n := rand.Intn(s.totalWeight)
+	for i := range s.Requests {
+		n -= s.Requests[i].Weight
+		if n < 0 {
+			return &s.Requests[i]
+		}
+	}
+	return &s.Requests[len(s.Requests)-1]
+}
+
+type templateVars struct {
+	VU        int
+	Iteration int
+}
+
+func (rt *RequestTemplate) build(ctx context.Context, baseURL string, vars templateVars) (*http.Request, error) {
+	var path bytes.Buffer
+	if err := rt.pathTmpl.Execute(&path, vars); err != nil {
+		return nil, err
+	}
+
+	var body io.Reader = http.NoBody
+	if rt.bodyTmpl != nil {
+		var buf bytes.Buffer
+		if err := rt.bodyTmpl.Execute(&buf, vars); err != nil {
+			return nil, err
+		}
+		body = &buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, rt.Method, baseURL+path.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if rt.bodyTmpl != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range rt.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// ============================================================================
+// LOAD TEST RESULTS - Per-endpoint latency, status codes, throughput
+// ============================================================================
+
+type LatencySummary struct {
+	Count  int     `json:"count"`
+	MinMs  float64 `json:"min_ms"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P95Ms  float64 `json:"p95_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+type EndpointSummary struct {
+	Latency     LatencySummary `json:"latency"`
+	Errors      int            `json:"errors"`
+	StatusCodes map[string]int `json:"status_codes"`
+}
+
+type ThroughputSample struct {
+	OffsetSeconds float64 `json:"offset_seconds"`
+	Requests      int64   `json:"requests"`
+	Errors        int64   `json:"errors"`
+	RPS           float64 `json:"rps"`
+}
+
+// LoadTestSummary is the result of a run. It serialises to JSON so runs
+// can be diffed against each other. Errors are transport failures and
+// responses outside the request's expected statuses; ClientErrors are the
+// 4xx among them, which usually point at the scenario rather than the
+// server.
+type LoadTestSummary struct {
+	Scenario      string                      `json:"scenario"`
+	StartedAt     time.Time                   `json:"started_at"`
+	Elapsed       Duration                    `json:"elapsed"`
+	TotalRequests int64                       `json:"total_requests"`
+	TotalErrors   int64                       `json:"total_errors"`
+	ClientErrors  int64                       `json:"client_errors"`
+	AvgRPS        float64                     `json:"avg_rps"`
+	StatusCodes   map[string]int              `json:"status_codes"`
+	Endpoints     map[string]*EndpointSummary `json:"endpoints"`
+	Throughput    []ThroughputSample          `json:"throughput"`
+}
+
+type loadCollector struct {
+	mu          sync.Mutex
+	latencies   map[string][]time.Duration
+	statusCodes map[string]map[string]int
+	failures    map[string]int
+
+	requests     int64
+	errors       int64
+	clientErrors int64
+}
+
+func newLoadCollector() *loadCollector {
+	return &loadCollector{
+		latencies:   make(map[string][]time.Duration),
+		statusCodes: make(map[string]map[string]int),
+		failures:    make(map[string]int),
+	}
+}
+
+// record stores one request outcome. code is the HTTP status, or 0 for
+// transport failures.
+func (c *loadCollector) record(endpoint string, code int, latency time.Duration, failed bool) {
+	status := "error"
+	if code != 0 {
+		status = strconv.Itoa(code)
+	}
+
+	atomic.AddInt64(&c.requests, 1)
+	if failed {
+		atomic.AddInt64(&c.errors, 1)
+		if code >= 400 && code < 500 {
+			atomic.AddInt64(&c.clientErrors, 1)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.latencies[endpoint] = append(c.latencies[endpoint], latency)
+	if failed {
+		c.failures[endpoint]++
+	}
+	codes, ok := c.statusCodes[endpoint]
+	if !ok {
+		codes = make(map[string]int)
+		c.statusCodes[endpoint] = codes
+	}
+	codes[status]++
+}
+
+func (c *loadCollector) counts() (int64, int64) {
+	return atomic.LoadInt64(&c.requests), atomic.LoadInt64(&c.errors)
+}
+
+func summarizeLatencies(samples []time.Duration) LatencySummary {
+	if len(samples) == 0 {
+		return LatencySummary{}
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	pct := func(p float64) float64 {
+		idx := int(p*float64(len(sorted))+0.5) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= len(sorted) {
+			idx = len(sorted) - 1
+		}
+		return ms(sorted[idx])
+	}
+
+	return LatencySummary{
+		Count:  len(sorted),
+		MinMs:  ms(sorted[0]),
+		MeanMs: ms(total) / float64(len(sorted)),
+		P50Ms:  pct(0.50),
+		P90Ms:  pct(0.90),
+		P95Ms:  pct(0.95),
+		P99Ms:  pct(0.99),
+		MaxMs:  ms(sorted[len(sorted)-1]),
+	}
+}
+
+func (c *loadCollector) summary(s *Scenario, started time.Time, throughput []ThroughputSample) *LoadTestSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	total, errs := c.counts()
+	elapsed := time.Since(started)
+
+	sum := &LoadTestSummary{
+		Scenario:      s.Name,
+		StartedAt:     started,
+		Elapsed:       Duration(elapsed),
+		TotalRequests: total,
+		TotalErrors:   errs,
+		ClientErrors:  atomic.LoadInt64(&c.clientErrors),
+		AvgRPS:        float64(total) / elapsed.Seconds(),
+		StatusCodes:   make(map[string]int),
+		Endpoints:     make(map[string]*EndpointSummary),
+		Throughput:    throughput,
+	}
+
+	for endpoint, samples := range c.latencies {
+		ep := &EndpointSummary{
+			Latency:     summarizeLatencies(samples),
+			Errors:      c.failures[endpoint],
+			StatusCodes: make(map[string]int),
+		}
+		for code, n := range c.statusCodes[endpoint] {
+			ep.StatusCodes[code] = n
+			sum.StatusCodes[code] += n
+		}
+		sum.Endpoints[endpoint] = ep
+	}
+
+	return sum
+}
+
+// WriteJSON writes the summary to path for later comparison.
+func (s *LoadTestSummary) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (s *LoadTestSummary) Log() {
+	log.Printf("Load test %q complete - Total: %d, Errors: %d (%d 4xx), Avg RPS: %.1f",
+		s.Scenario, s.TotalRequests, s.TotalErrors, s.ClientErrors, s.AvgRPS)
+
+	names := make([]string, 0, len(s.Endpoints))
+	for name := range s.Endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ep := s.Endpoints[name]
+		log.Printf("  %-14s n=%-6d errors=%-5d p50=%.1fms p95=%.1fms p99=%.1fms max=%.1fms codes=%v",
+			name, ep.Latency.Count, ep.Errors, ep.Latency.P50Ms, ep.Latency.P95Ms,
+			ep.Latency.P99Ms, ep.Latency.MaxMs, ep.StatusCodes)
+	}
+}
+
+// ============================================================================
+// LOAD TEST RUNNER
+// ============================================================================
+
+func runVirtualUser(ctx context.Context, vu int, baseURL string, s *Scenario, c *loadCollector) {
+	client := &http.Client{Timeout: s.RequestTimeout.Std()}
+
+	for iter := 0; ; iter++ {
+		if ctx.Err() != nil {
+			return
+		}
+
+		rt := s.pick()
+		req, err := rt.build(ctx, baseURL, templateVars{VU: vu, Iteration: iter})
+		if err != nil {
+			log.Printf("VU %d: building %s: %v", vu, rt.Name, err)
+			return
+		}
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		latency := time.Since(start)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return // run ended mid-request; don't count it
+			}
+			c.record(rt.Name, 0, latency, true)
+		} else {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			c.record(rt.Name, resp.StatusCode, latency, !rt.expect(resp.StatusCode))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.ThinkTime.next()):
+		}
+	}
+}
+
+// runLoadTest drives baseURL with the given scenario and returns a summary.
+func runLoadTest(baseURL string, s *Scenario) *LoadTestSummary {
+	log.Printf("Starting load test %q: %d VUs, ramp-up %v, duration %v",
+		s.Name, s.VirtualUsers, s.RampUp.Std(), s.Duration.Std())
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Duration.Std())
+	defer cancel()
+
+	collector := newLoadCollector()
+	started := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.VirtualUsers; i++ {
+		// Stagger VU start times evenly across the ramp-up window
+		delay := time.Duration(int64(s.RampUp) * int64(i) / int64(s.VirtualUsers))
+
+		wg.Add(1)
+		go func(vu int) {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			runVirtualUser(ctx, vu, baseURL, s, collector)
+		}(i)
+	}
+
+	// Throughput sampler
+	var throughput []ThroughputSample
+	samplerDone := make(chan struct{})
+	go func() {
+		defer close(samplerDone)
+
+		interval := s.ReportInterval.Std()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var lastReq, lastErr int64
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				req, errs := collector.counts()
+				throughput = append(throughput, ThroughputSample{
+					OffsetSeconds: now.Sub(started).Seconds(),
+					Requests:      req - lastReq,
+					Errors:        errs - lastErr,
+					RPS:           float64(req-lastReq) / interval.Seconds(),
+				})
+				lastReq, lastErr = req, errs
+
+				log.Printf("Load test - Requests: %d, Errors: %d, Error Rate: %.2f%%",
+					req, errs, float64(errs)/float64(max(req, 1))*100)
+			}
+		}
+	}()
+
+	wg.Wait()
+	<-samplerDone
+
+	summary := collector.summary(s, started, throughput)
+	summary.Log()
+	return summary
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"container/heap"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,6 +24,8 @@ type Analytics struct {
 	requestCount    map[string]int64   // endpoint -> count
 	errorCount      map[string]int64   // error type -> count
 	avgResponseTime map[string]float64 // endpoint -> avg ms
+	cancelledCount  map[string]int64   // stage -> count
+	wastedWorkMs    map[string]int64   // task type -> ms spent on cancelled tasks
 }
 
 func NewAnalytics() *Analytics {
@@ -28,6 +33,8 @@ func NewAnalytics() *Analytics {
 		requestCount:    make(map[string]int64),
 		errorCount:      make(map[string]int64),
 		avgResponseTime: make(map[string]float64),
+		cancelledCount:  make(map[string]int64),
+		wastedWorkMs:    make(map[string]int64),
 	}
 }
 
@@ -51,6 +58,17 @@ func (a *Analytics) RecordError(errorType string) {
 	a.errorCount[errorType]++
 }
 
+// RecordCancelled counts a task whose request went away. stage is where
+// the cancellation was noticed ("queued", "processing" or "result") and
+// wasted is the worker time already spent on it.
+func (a *Analytics) RecordCancelled(stage, taskType string, wasted time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.cancelledCount[stage]++
+	a.wastedWorkMs[taskType] += wasted.Milliseconds()
+}
+
 func (a *Analytics) GetStats() map[string]interface{} {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
@@ -60,6 +78,8 @@ func (a *Analytics) GetStats() map[string]interface{} {
 		"request_count":     copyMap(a.requestCount),
 		"error_count":       copyMap(a.errorCount),
 		"avg_response_time": copyMapFloat(a.avgResponseTime),
+		"cancelled_count":   copyMap(a.cancelledCount),
+		"wasted_work_ms":    copyMap(a.wastedWorkMs),
 	}
 
 	return stats
@@ -86,13 +106,41 @@ func copyMapFloat(m map[string]float64) map[string]float64 {
 // ============================================================================
 
 type CacheEntry struct {
+	key       string
 	value     interface{}
 	expiresAt time.Time
+	index     int // position in Cache.expiry
+}
+
+// expiryHeap orders entries by expiry, soonest first, so eviction and
+// cleanup never scan the whole cache
+type expiryHeap []*CacheEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *expiryHeap) Push(x any) {
+	e := x.(*CacheEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *expiryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
 }
 
 type Cache struct {
-	mu      sync.RWMutex
-	entries map[string]*CacheEntry
+	mu         sync.RWMutex
+	entries    map[string]*CacheEntry
+	expiry     expiryHeap
+	maxEntries int // 0 means unbounded
 }
 
 func NewCache() *Cache {
@@ -122,10 +170,75 @@ func (c *Cache) Set(key string, value interface{}, ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.entries[key] = &CacheEntry{
+	if entry, exists := c.entries[key]; exists {
+		entry.value = value
+		entry.expiresAt = time.Now().Add(ttl)
+		heap.Fix(&c.expiry, entry.index)
+		return
+	}
+
+	if c.maxEntries > 0 {
+		c.evictLocked(c.maxEntries - 1)
+	}
+
+	entry := &CacheEntry{
+		key:       key,
 		value:     value,
 		expiresAt: time.Now().Add(ttl),
 	}
+	c.entries[key] = entry
+	heap.Push(&c.expiry, entry)
+}
+
+// evictLocked drops entries closest to expiry until at most n remain.
+func (c *Cache) evictLocked(n int) int {
+	evicted := 0
+	for len(c.entries) > n {
+		entry := heap.Pop(&c.expiry).(*CacheEntry)
+		delete(c.entries, entry.key)
+		evicted++
+	}
+	return evicted
+}
+
+// Flush removes every entry and returns how many were dropped.
+func (c *Cache) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.entries)
+	c.entries = make(map[string]*CacheEntry)
+	c.expiry = nil
+	return n
+}
+
+// Resize caps the cache at maxEntries (0 for unbounded), evicting entries
+// closest to expiry if it is already over the new limit.
+func (c *Cache) Resize(maxEntries int) (int, error) {
+	if maxEntries < 0 {
+		return 0, fmt.Errorf("max entries must not be negative")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxEntries = maxEntries
+	if maxEntries == 0 {
+		return 0, nil
+	}
+	return c.evictLocked(maxEntries), nil
+}
+
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+func (c *Cache) MaxEntries() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.maxEntries
 }
 
 func (c *Cache) cleanupExpired() {
@@ -135,10 +248,9 @@ func (c *Cache) cleanupExpired() {
 	for range ticker.C {
 		c.mu.Lock()
 		now := time.Now()
-		for key, entry := range c.entries {
-			if now.After(entry.expiresAt) {
-				delete(c.entries, key)
-			}
+		for len(c.expiry) > 0 && now.After(c.expiry[0].expiresAt) {
+			entry := heap.Pop(&c.expiry).(*CacheEntry)
+			delete(c.entries, entry.key)
 		}
 		c.mu.Unlock()
 	}
@@ -151,8 +263,19 @@ func (c *Cache) cleanupExpired() {
 type Task struct {
 	ID       string
 	Type     string
-	Payload  map[string]interface{}
+	Payload  json.RawMessage // wire form, as submitted and persisted
+	Params   interface{}     // Payload decoded by the TaskRegistry
 	ResultCh chan TaskResult
+	Ctx      context.Context // request scope: deadline and client cancellation
+}
+
+// Context returns the task's request context, or Background for tasks
+// without one (e.g. replayed from a durable queue).
+func (t Task) Context() context.Context {
+	if t.Ctx == nil {
+		return context.Background()
+	}
+	return t.Ctx
 }
 
 type TaskResult struct {
@@ -162,27 +285,89 @@ type TaskResult struct {
 }
 
 type TaskProcessor struct {
-	taskQueue      chan Task
-	numWorkers     int
+	queue          TaskQueue
+	registry       *TaskRegistry
 	wg             sync.WaitGroup
+	cancel         context.CancelFunc
 	analytics      *Analytics
 	tasksProcessed int64
+
+	// Worker pool, resizable at runtime. Each worker has its own cancel so
+	// shrinking stops idle workers without touching in-flight tasks.
+	mu            sync.Mutex
+	ctx           context.Context
+	numWorkers    int
+	workerCancels []context.CancelFunc
+	nextWorkerID  int
 }
 
+// NewTaskProcessor creates a processor backed by an in-memory queue.
 func NewTaskProcessor(queueSize, numWorkers int, analytics *Analytics) *TaskProcessor {
+	return NewTaskProcessorWithQueue(NewMemoryQueue(queueSize), numWorkers, analytics)
+}
+
+// NewTaskProcessorWithQueue creates a processor on top of any TaskQueue,
+// e.g. a WALQueue for crash recovery.
+func NewTaskProcessorWithQueue(queue TaskQueue, numWorkers int, analytics *Analytics) *TaskProcessor {
 	return &TaskProcessor{
-		taskQueue:  make(chan Task, queueSize),
+		queue:      queue,
+		registry:   DefaultTaskRegistry(),
 		numWorkers: numWorkers,
 		analytics:  analytics,
 	}
 }
 
 func (tp *TaskProcessor) Start(ctx context.Context) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	tp.ctx, tp.cancel = context.WithCancel(ctx)
+
 	// Start worker pool
-	for i := 0; i < tp.numWorkers; i++ {
+	tp.scaleLocked(tp.numWorkers)
+}
+
+// Resize grows or shrinks the worker pool. Removed workers finish the
+// task they are running before exiting.
+func (tp *TaskProcessor) Resize(n int) error {
+	if n < 1 {
+		return fmt.Errorf("worker count must be at least 1")
+	}
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	if tp.ctx == nil {
+		tp.numWorkers = n
+		return nil
+	}
+	if tp.ctx.Err() != nil {
+		return fmt.Errorf("task processor stopped")
+	}
+	tp.scaleLocked(n)
+	return nil
+}
+
+func (tp *TaskProcessor) scaleLocked(n int) {
+	for len(tp.workerCancels) < n {
+		ctx, cancel := context.WithCancel(tp.ctx)
+		tp.workerCancels = append(tp.workerCancels, cancel)
 		tp.wg.Add(1)
-		go tp.worker(ctx, i)
+		go tp.worker(ctx, tp.nextWorkerID)
+		tp.nextWorkerID++
+	}
+	for len(tp.workerCancels) > n {
+		last := len(tp.workerCancels) - 1
+		tp.workerCancels[last]()
+		tp.workerCancels = tp.workerCancels[:last]
 	}
+	tp.numWorkers = n
+}
+
+func (tp *TaskProcessor) WorkerCount() int {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.numWorkers
 }
 
 func (tp *TaskProcessor) worker(ctx context.Context, id int) {
@@ -192,14 +377,15 @@ func (tp *TaskProcessor) worker(ctx context.Context, id int) {
 
 	for {
 		select {
-		case task := <-tp.taskQueue:
-			result := tp.processTask(task)
-
-			// Send result back
-			select {
-			case task.ResultCh <- result:
-			case <-time.After(1 * time.Second):
-				log.Printf("Worker %d: timeout sending result for task %s", id, task.ID)
+		case task, ok := <-tp.queue.Tasks():
+			if !ok {
+				return
+			}
+			tp.runTask(id, task)
+
+			// Acknowledge only after completion so a crash replays the task
+			if err := tp.queue.Ack(task.ID); err != nil {
+				log.Printf("Worker %d: ack %s: %v", id, task.ID, err)
 			}
 
 			atomic.AddInt64(&tp.tasksProcessed, 1)
@@ -211,31 +397,114 @@ func (tp *TaskProcessor) worker(ctx context.Context, id int) {
 	}
 }
 
-func (tp *TaskProcessor) processTask(task Task) TaskResult {
+// runTask processes one task and delivers its result, skipping work whose
+// request has already been cancelled.
+func (tp *TaskProcessor) runTask(workerID int, task Task) {
+	ctx := task.Context()
+
+	if ctx.Err() != nil {
+		tp.analytics.RecordCancelled("queued", task.Type, 0)
+		return
+	}
+
+	start := time.Now()
+	result := tp.processTask(ctx, task)
+	if ctx.Err() != nil {
+		tp.analytics.RecordCancelled("processing", task.Type, time.Since(start))
+		return
+	}
+
+	// Send result back (replayed tasks have no waiting client)
+	if task.ResultCh == nil {
+		return
+	}
+	select {
+	case task.ResultCh <- result:
+	case <-ctx.Done():
+		tp.analytics.RecordCancelled("result", task.Type, time.Since(start))
+	case <-time.After(1 * time.Second):
+		log.Printf("Worker %d: timeout sending result for task %s", workerID, task.ID)
+	}
+}
+
+// simulateWork sleeps for d unless ctx is cancelled first.
+func simulateWork(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (tp *TaskProcessor) processTask(ctx context.Context, task Task) TaskResult {
+	// Replayed tasks arrive with only the raw payload
+	params := task.Params
+	if params == nil {
+		decoded, err := tp.registry.Decode(task.Type, task.Payload)
+		if err != nil {
+			tp.analytics.RecordError("invalid_payload")
+			return TaskResult{TaskID: task.ID, Error: err}
+		}
+		params = decoded
+	}
+
 	// Simulate different task types with varying processing times
 	var result interface{}
 	var err error
 
-	switch task.Type {
-	case "compute":
-		time.Sleep(time.Duration(50+rand.Intn(100)) * time.Millisecond)
+	switch p := params.(type) {
+	case *ComputePayload:
+		if err = simulateWork(ctx, time.Duration(50+rand.Intn(100))*time.Millisecond); err != nil {
+			break
+		}
+		value := p.Value
+		switch p.Operation {
+		case "square":
+			value *= value
+		case "double":
+			value *= 2
+		case "negate":
+			value = -value
+		}
 		result = map[string]interface{}{
 			"computation": "completed",
-			"value":       rand.Intn(1000),
+			"value":       value,
+		}
+	case *DatabasePayload:
+		if err = simulateWork(ctx, time.Duration(100+rand.Intn(200))*time.Millisecond); err != nil {
+			break
+		}
+		limit := p.Limit
+		if limit == 0 {
+			limit = 100
 		}
-	case "database":
-		time.Sleep(time.Duration(100+rand.Intn(200)) * time.Millisecond)
 		result = map[string]interface{}{
-			"records": rand.Intn(100),
+			"table":   p.Table,
+			"records": rand.Intn(limit),
+		}
+	case *ExternalAPIPayload:
+		// timeout_ms bounds the call itself, inside the task's deadline
+		callCtx := ctx
+		if p.TimeoutMs > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeout(ctx, time.Duration(p.TimeoutMs)*time.Millisecond)
+			defer cancel()
+		}
+		if err = simulateWork(callCtx, time.Duration(200+rand.Intn(300))*time.Millisecond); err != nil {
+			if ctx.Err() == nil {
+				err = fmt.Errorf("external API timeout calling %s after %dms", p.Endpoint, p.TimeoutMs)
+				tp.analytics.RecordError("external_api_timeout")
+			}
+			break
 		}
-	case "external_api":
-		time.Sleep(time.Duration(200+rand.Intn(300)) * time.Millisecond)
 		if rand.Float32() < 0.1 {
-			err = fmt.Errorf("external API timeout")
+			err = fmt.Errorf("external API timeout calling %s", p.Endpoint)
 			tp.analytics.RecordError("external_api_timeout")
 		} else {
 			result = map[string]interface{}{
-				"status": "success",
+				"endpoint": p.Endpoint,
+				"status":   "success",
 			}
 		}
 	default:
@@ -251,17 +520,21 @@ func (tp *TaskProcessor) processTask(task Task) TaskResult {
 }
 
 func (tp *TaskProcessor) SubmitTask(task Task) error {
-	select {
-	case tp.taskQueue <- task:
-		return nil
-	case <-time.After(100 * time.Millisecond):
-		return fmt.Errorf("task queue full")
-	}
+	return tp.queue.Push(task, 100*time.Millisecond)
 }
 
+// Stop waits for in-flight tasks to finish, then closes the queue. Tasks
+// still queued in a durable backend are replayed on the next start.
 func (tp *TaskProcessor) Stop() {
-	close(tp.taskQueue)
+	tp.mu.Lock()
+	if tp.cancel != nil {
+		tp.cancel()
+	}
+	tp.mu.Unlock()
 	tp.wg.Wait()
+	if err := tp.queue.Close(); err != nil {
+		log.Printf("Task queue close: %v", err)
+	}
 }
 
 func (tp *TaskProcessor) GetProcessedCount() int64 {
@@ -269,30 +542,56 @@ func (tp *TaskProcessor) GetProcessedCount() int64 {
 }
 
 // ============================================================================
-// RATE LIMITER - Semaphore (Buffered Channel)
+// RATE LIMITER - Counting semaphore (Mutex + Cond) with adjustable capacity
 // ============================================================================
 
 type RateLimiter struct {
-	sem           chan struct{}
+	mu            sync.Mutex
+	cond          *sync.Cond
 	maxConcurrent int
 	activeCount   int64
 }
 
 func NewRateLimiter(maxConcurrent int) *RateLimiter {
-	return &RateLimiter{
-		sem:           make(chan struct{}, maxConcurrent),
-		maxConcurrent: maxConcurrent,
-	}
+	rl := &RateLimiter{maxConcurrent: maxConcurrent}
+	rl.cond = sync.NewCond(&rl.mu)
+	return rl
 }
 
 func (rl *RateLimiter) Acquire() {
-	rl.sem <- struct{}{}
+	rl.mu.Lock()
+	for rl.activeCount >= int64(rl.maxConcurrent) {
+		rl.cond.Wait()
+	}
 	atomic.AddInt64(&rl.activeCount, 1)
+	rl.mu.Unlock()
 }
 
 func (rl *RateLimiter) Release() {
-	<-rl.sem
+	rl.mu.Lock()
 	atomic.AddInt64(&rl.activeCount, -1)
+	rl.mu.Unlock()
+	rl.cond.Signal()
+}
+
+// SetMaxConcurrent changes capacity. Lowering it never interrupts holders;
+// new callers simply wait until active drops below the new limit.
+func (rl *RateLimiter) SetMaxConcurrent(n int) error {
+	if n < 1 {
+		return fmt.Errorf("max concurrency must be at least 1")
+	}
+
+	rl.mu.Lock()
+	rl.maxConcurrent = n
+	rl.mu.Unlock()
+	rl.cond.Broadcast()
+	return nil
+}
+
+func (rl *RateLimiter) MaxConcurrent() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.maxConcurrent
 }
 
 func (rl *RateLimiter) GetActiveCount() int64 {
@@ -308,17 +607,39 @@ type Server struct {
 	cache         *Cache
 	taskProcessor *TaskProcessor
 	rateLimiter   *RateLimiter
+	bootID        string // keeps task IDs unique across restarts
 	reqCounter    int64
+	adminToken    string
+	audit         *AuditLog
 }
 
 func NewServer() *Server {
 	analytics := NewAnalytics()
 
+	// TASK_QUEUE_WAL=path switches to a durable, replayable queue
+	taskProcessor := NewTaskProcessor(1000, 50, analytics) // 50 workers, 1000 queue size
+	if path := os.Getenv("TASK_QUEUE_WAL"); path != "" {
+		queue, err := OpenWALQueue(path, 1000)
+		if err != nil {
+			log.Fatalf("Task queue: %v", err)
+		}
+		taskProcessor = NewTaskProcessorWithQueue(queue, 50, analytics)
+	}
+
+	// ADMIN_TOKEN enables /admin; changes go to ADMIN_AUDIT_LOG (or stderr)
+	audit, err := NewAuditLog(os.Getenv("ADMIN_AUDIT_LOG"))
+	if err != nil {
+		log.Fatalf("Admin: %v", err)
+	}
+
 	return &Server{
 		analytics:     analytics,
 		cache:         NewCache(),
-		taskProcessor: NewTaskProcessor(1000, 50, analytics), // 50 workers, 1000 queue size
-		rateLimiter:   NewRateLimiter(100),                   // Max 100 concurrent requests
+		taskProcessor: taskProcessor,
+		rateLimiter:   NewRateLimiter(100), // Max 100 concurrent requests
+		bootID:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		adminToken:    os.Getenv("ADMIN_TOKEN"),
+		audit:         audit,
 	}
 }
 
@@ -330,7 +651,9 @@ func (s *Server) Start(ctx context.Context) {
 	http.HandleFunc("/api/process", s.handleProcess)
 	http.HandleFunc("/api/cached-data", s.handleCachedData)
 	http.HandleFunc("/api/stats", s.handleStats)
+	http.HandleFunc("/api/task-types", s.handleTaskTypes)
 	http.HandleFunc("/health", s.handleHealth)
+	s.registerAdmin()
 
 	// Start server
 	srv := &http.Server{
@@ -382,8 +705,8 @@ func (s *Server) handleProcess(w http.ResponseWriter, r *http.Request) {
 
 	// Parse request
 	var req struct {
-		TaskType string                 `json:"task_type"`
-		Payload  map[string]interface{} `json:"payload"`
+		TaskType string          `json:"task_type"`
+		Payload  json.RawMessage `json:"payload"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -392,12 +715,31 @@ func (s *Server) handleProcess(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate against the task type's schema before queuing
+	params, err := s.taskProcessor.registry.Decode(req.TaskType, req.Payload)
+	if err != nil {
+		s.analytics.RecordError("invalid_payload")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "invalid payload",
+			"details": err,
+		})
+		return
+	}
+
+	// Task lives as long as the request, capped at 5s
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
 	// Create task
 	task := Task{
-		ID:       fmt.Sprintf("task-%d", reqID),
+		ID:       fmt.Sprintf("task-%s-%d", s.bootID, reqID),
 		Type:     req.TaskType,
 		Payload:  req.Payload,
+		Params:   params,
 		ResultCh: make(chan TaskResult, 1),
+		Ctx:      ctx,
 	}
 
 	// Submit to worker pool (producer)
@@ -423,9 +765,14 @@ func (s *Server) handleProcess(w http.ResponseWriter, r *http.Request) {
 			"active_requests": s.rateLimiter.GetActiveCount(),
 		})
 
-	case <-time.After(5 * time.Second):
-		s.analytics.RecordError("task_timeout")
-		http.Error(w, "Request timeout", http.StatusGatewayTimeout)
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			s.analytics.RecordError("task_timeout")
+			http.Error(w, "Request timeout", http.StatusGatewayTimeout)
+			return
+		}
+		// Client went away; nobody to respond to
+		s.analytics.RecordError("client_disconnected")
 	}
 }
 
@@ -490,106 +837,36 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 
 	stats["tasks_processed"] = s.taskProcessor.GetProcessedCount()
 	stats["active_requests"] = s.rateLimiter.GetActiveCount()
-	stats["max_concurrent"] = s.rateLimiter.maxConcurrent
-	stats["worker_count"] = s.taskProcessor.numWorkers
+	stats["max_concurrent"] = s.rateLimiter.MaxConcurrent()
+	stats["worker_count"] = s.taskProcessor.WorkerCount()
+	stats["cache_entries"] = s.cache.Len()
+	stats["queue_depth"] = s.taskProcessor.queue.Len()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
 
 // ============================================================================
-// HANDLER: Health Check
+// HANDLER: Task Types (registered payload schemas)
 // ============================================================================
 
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleTaskTypes(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "healthy",
-		"timestamp": time.Now().Unix(),
+		"task_types": s.taskProcessor.registry.Types(),
 	})
 }
 
 // ============================================================================
-// LOAD TESTER
+// HANDLER: Health Check
 // ============================================================================
 
-func runLoadTest(baseURL string, duration time.Duration) {
-	log.Println("Starting load test...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), duration)
-	defer cancel()
-
-	var wg sync.WaitGroup
-	requestCount := int64(0)
-	errorCount := int64(0)
-
-	// Simulate 200 concurrent clients
-	for i := 0; i < 200; i++ {
-		wg.Add(1)
-		go func(clientID int) {
-			defer wg.Done()
-
-			client := &http.Client{Timeout: 10 * time.Second}
-
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					// Random endpoint
-					var resp *http.Response
-					var err error
-
-					switch rand.Intn(3) {
-					case 0:
-						// Process task
-						//body := `{"task_type":"compute","payload":{"value":123}}`
-						resp, err = client.Post(baseURL+"/api/process", "application/json",
-							http.NoBody)
-					case 1:
-						// Cached data
-						key := fmt.Sprintf("key_%d", rand.Intn(10))
-						resp, err = client.Get(baseURL + "/api/cached-data?key=" + key)
-					case 2:
-						// Stats
-						resp, err = client.Get(baseURL + "/api/stats")
-					}
-
-					atomic.AddInt64(&requestCount, 1)
-
-					if err != nil {
-						atomic.AddInt64(&errorCount, 1)
-						time.Sleep(10 * time.Millisecond)
-						continue
-					}
-
-					resp.Body.Close()
-
-					// Small delay between requests
-					time.Sleep(time.Duration(10+rand.Intn(20)) * time.Millisecond)
-				}
-			}
-		}(i)
-	}
-
-	// Stats reporter
-	ticker := time.NewTicker(2 * time.Second)
-	go func() {
-		for range ticker.C {
-			req := atomic.LoadInt64(&requestCount)
-			err := atomic.LoadInt64(&errorCount)
-			log.Printf("Load test - Requests: %d, Errors: %d, Error Rate: %.2f%%",
-				req, err, float64(err)/float64(req)*100)
-		}
-	}()
-
-	wg.Wait()
-	ticker.Stop()
-
-	total := atomic.LoadInt64(&requestCount)
-	errors := atomic.LoadInt64(&errorCount)
-	log.Printf("Load test complete - Total: %d, Errors: %d, Success Rate: %.2f%%",
-		total, errors, float64(total-errors)/float64(total)*100)
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "healthy",
+		"timestamp": time.Now().Unix(),
+	})
 }
 
 // ============================================================================
@@ -608,8 +885,23 @@ func prod() {
 	// Wait for server to start
 	time.Sleep(1 * time.Second)
 
-	// Run load test
-	runLoadTest("http://localhost:8080", 30*time.Second)
+	// Run load test (LOAD_SCENARIO selects a JSON/YAML scenario file,
+	// LOAD_REPORT writes the summary as JSON for comparing runs)
+	scenario := DefaultScenario(30 * time.Second)
+	if path := os.Getenv("LOAD_SCENARIO"); path != "" {
+		loaded, err := LoadScenario(path)
+		if err != nil {
+			log.Fatalf("Load scenario: %v", err)
+		}
+		scenario = loaded
+	}
+
+	summary := runLoadTest("http://localhost:8080", scenario)
+	if path := os.Getenv("LOAD_REPORT"); path != "" {
+		if err := summary.WriteJSON(path); err != nil {
+			log.Printf("Writing load report: %v", err)
+		}
+	}
 
 	// Let server run a bit more
 	time.Sleep(5 * time.Second)
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsSoonestExpiry(t *testing.T) {
+	c := NewCache()
+	for i := range 100 {
+		// Later keys live longer, except k0 which is refreshed below
+		c.Set(fmt.Sprintf("k%d", i), i, time.Duration(i+1)*time.Minute)
+	}
+	c.Set("k0", "refreshed", 3*time.Hour)
+
+	if n, err := c.Resize(10); err != nil || n != 90 {
+		t.Fatalf("Resize = %d, %v, want 90 evicted", n, err)
+	}
+	if v, ok := c.Get("k0"); !ok || v != "refreshed" {
+		t.Errorf("k0 = %v, %v, want the refreshed entry kept", v, ok)
+	}
+	for i := 91; i < 100; i++ {
+		if _, ok := c.Get(fmt.Sprintf("k%d", i)); !ok {
+			t.Errorf("k%d evicted, want it kept", i)
+		}
+	}
+
+	// At capacity, a new key pushes out the one closest to expiry
+	c.Set("new", 1, 4*time.Hour)
+	if _, ok := c.Get("k91"); ok || c.Len() != 10 {
+		t.Errorf("len %d, k91 present %v, want k91 evicted", c.Len(), ok)
+	}
+
+	if n := c.Flush(); n != 10 || c.Len() != 0 {
+		t.Errorf("Flush = %d, len %d", n, c.Len())
+	}
+	c.Set("after", 1, time.Minute)
+	if _, ok := c.Get("after"); !ok {
+		t.Error("Set after Flush lost the entry")
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// TASK QUEUE BACKENDS - In-memory channel (default) or write-ahead log
+// ============================================================================
+
+// TaskQueue is the storage behind TaskProcessor. Push hands a task to the
+// queue, workers receive from Tasks() and call Ack once a task has
+// completed. Backends that persist tasks replay un-acked ones on startup.
+type TaskQueue interface {
+	Push(task Task, timeout time.Duration) error
+	Tasks() <-chan Task
+	Ack(taskID string) error
+	Len() int
+	Close() error
+}
+
+var errQueueFull = fmt.Errorf("task queue full")
+var errQueueClosed = fmt.Errorf("task queue closed")
+
+// ----------------------------------------------------------------------------
+// MemoryQueue - buffered channel, lost on restart
+// ----------------------------------------------------------------------------
+
+type MemoryQueue struct {
+	mu     sync.RWMutex
+	ch     chan Task
+	closed bool
+}
+
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{ch: make(chan Task, size)}
+}
+
+func (q *MemoryQueue) Push(task Task, timeout time.Duration) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	select {
+	case q.ch <- task:
+		return nil
+	case <-time.After(timeout):
+		return errQueueFull
+	}
+}
+
+func (q *MemoryQueue) Tasks() <-chan Task { return q.ch }
+
+func (q *MemoryQueue) Ack(taskID string) error { return nil }
+
+func (q *MemoryQueue) Len() int { return len(q.ch) }
+
+func (q *MemoryQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// WALQueue - append-only log, replayed on startup
+// ----------------------------------------------------------------------------
+
+// walRecord is one line of the log. Enqueue records carry the task,
+// ack records only its ID.
+type walRecord struct {
+	Op      string          `json:"op"` // "enqueue" or "ack"
+	ID      string          `json:"id"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Type    string          `json:"type,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// compactThreshold is how many acks accumulate before the log is rewritten
+// to hold only pending tasks.
+const compactThreshold = 1000
+
+type WALQueue struct {
+	mu      sync.RWMutex // RLock for Push/Ack, Lock for compact/Close
+	logMu   sync.Mutex   // serialises file writes and the fields below
+	path    string
+	file    *os.File
+	ch      chan Task
+	pending map[string]walRecord
+	seq     uint64
+	acked   int
+	closed  bool
+
+	compactAfter int // acks before compaction is considered; compactThreshold
+}
+
+// OpenWALQueue opens (or creates) the log at path, replays any tasks that
+// were enqueued but never acked, and compacts the log.
+func OpenWALQueue(path string, size int) (*WALQueue, error) {
+	pending, order, err := replayWAL(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replayed tasks must fit in the buffer alongside new work
+	q := &WALQueue{
+		path:    path,
+		ch:      make(chan Task, size+len(order)),
+		pending: pending,
+
+		compactAfter: compactThreshold,
+	}
+
+	if err := q.rewrite(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range order {
+		rec := pending[id]
+		q.seq = max(q.seq, rec.Seq)
+		q.ch <- Task{ID: rec.ID, Type: rec.Type, Payload: rec.Payload}
+	}
+	if len(order) > 0 {
+		log.Printf("Task queue: replayed %d un-acked tasks from %s", len(order), path)
+	}
+
+	return q, nil
+}
+
+// replayWAL reads the log and returns tasks that were never acked, in
+// enqueue order. A torn final line from a crash is skipped.
+func replayWAL(path string) (map[string]walRecord, []string, error) {
+	pending := make(map[string]walRecord)
+	var order []string
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return pending, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("open task log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var rec walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			log.Printf("Task queue: skipping corrupt log line: %v", err)
+			continue
+		}
+		switch rec.Op {
+		case "enqueue":
+			if _, dup := pending[rec.ID]; !dup {
+				order = append(order, rec.ID)
+			}
+			pending[rec.ID] = rec
+		case "ack":
+			delete(pending, rec.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("read task log: %w", err)
+	}
+
+	// Drop IDs acked after they were enqueued
+	live := order[:0]
+	for _, id := range order {
+		if _, ok := pending[id]; ok {
+			live = append(live, id)
+		}
+	}
+	sort.SliceStable(live, func(i, j int) bool {
+		return pending[live[i]].Seq < pending[live[j]].Seq
+	})
+	return pending, live, nil
+}
+
+// rewrite atomically replaces the log with enqueue records for pending
+// tasks only. Caller must hold the write lock (or be the constructor).
+func (q *WALQueue) rewrite() error {
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("compact task log: %w", err)
+	}
+
+	recs := make([]walRecord, 0, len(q.pending))
+	for _, rec := range q.pending {
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Seq < recs[j].Seq })
+
+	enc := json.NewEncoder(f)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return fmt.Errorf("compact task log: %w", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("compact task log: %w", err)
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, q.path); err != nil {
+		return fmt.Errorf("compact task log: %w", err)
+	}
+
+	if q.file != nil {
+		q.file.Close()
+	}
+	q.file, err = os.OpenFile(q.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("reopen task log: %w", err)
+	}
+	q.acked = 0
+	return nil
+}
+
+// append writes one record and fsyncs it.
+func (q *WALQueue) append(rec walRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	q.logMu.Lock()
+	defer q.logMu.Unlock()
+
+	if _, err := q.file.Write(data); err != nil {
+		return fmt.Errorf("write task log: %w", err)
+	}
+	return q.file.Sync()
+}
+
+func (q *WALQueue) Push(task Task, timeout time.Duration) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	q.logMu.Lock()
+	q.seq++
+	rec := walRecord{Op: "enqueue", ID: task.ID, Seq: q.seq, Type: task.Type, Payload: task.Payload}
+	q.logMu.Unlock()
+
+	if err := q.append(rec); err != nil {
+		return err
+	}
+	q.logMu.Lock()
+	q.pending[task.ID] = rec
+	q.logMu.Unlock()
+
+	select {
+	case q.ch <- task:
+		return nil
+	case <-time.After(timeout):
+		// Never handed to a worker; retract it so it isn't replayed
+		q.logMu.Lock()
+		delete(q.pending, task.ID)
+		q.logMu.Unlock()
+		if err := q.append(walRecord{Op: "ack", ID: task.ID}); err != nil {
+			log.Printf("Task queue: retracting %s: %v", task.ID, err)
+		}
+		return errQueueFull
+	}
+}
+
+func (q *WALQueue) Tasks() <-chan Task { return q.ch }
+
+func (q *WALQueue) Ack(taskID string) error {
+	q.mu.RLock()
+	if q.closed {
+		q.mu.RUnlock()
+		return errQueueClosed
+	}
+	if err := q.append(walRecord{Op: "ack", ID: taskID}); err != nil {
+		q.mu.RUnlock()
+		return err
+	}
+	q.logMu.Lock()
+	delete(q.pending, taskID)
+	q.acked++
+	compact := q.acked >= q.compactAfter && q.acked > 2*len(q.pending)
+	q.logMu.Unlock()
+	q.mu.RUnlock()
+
+	if compact {
+		return q.maybeCompact()
+	}
+	return nil
+}
+
+// maybeCompact rewrites the log once acks dominate it.
+func (q *WALQueue) maybeCompact() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || q.acked < q.compactAfter || q.acked <= 2*len(q.pending) {
+		return nil
+	}
+	return q.rewrite()
+}
+
+func (q *WALQueue) Len() int { return len(q.ch) }
+
+// Close stops accepting tasks and closes the log. Tasks still buffered
+// are left un-acked in the log and will be replayed on next open.
+func (q *WALQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.ch)
+	return q.file.Close()
+}
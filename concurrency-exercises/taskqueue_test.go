@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openTestWAL opens a queue on path and closes it when the test ends
+func openTestWAL(t *testing.T, path string) *WALQueue {
+	t.Helper()
+	q, err := OpenWALQueue(path, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+// drainIDs returns the IDs of the tasks buffered in q, in order
+func drainIDs(q *WALQueue) []string {
+	var ids []string
+	for {
+		select {
+		case task := <-q.Tasks():
+			ids = append(ids, task.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+// logLines reads the log's records
+func logLines(t *testing.T, path string) []walRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var recs []walRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("log line %q: %v", scanner.Text(), err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func pushAll(t *testing.T, q *WALQueue, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		task := Task{ID: id, Type: "compute", Payload: json.RawMessage(`{"value":1}`)}
+		if err := q.Push(task, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWALQueueReplaysUnackedTasks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.wal")
+
+	q := openTestWAL(t, path)
+	pushAll(t, q, "a", "b", "c", "d")
+	drainIDs(q) // handed to workers, which crash before acking all
+	if err := q.Ack("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack("d"); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	q = openTestWAL(t, path)
+	if got := drainIDs(q); !slices.Equal(got, []string{"a", "c"}) {
+		t.Fatalf("replayed %v, want [a c]", got)
+	}
+
+	// The replayed task keeps its type and payload
+	q.Close()
+	q = openTestWAL(t, path)
+	task := <-q.Tasks()
+	if task.Type != "compute" || string(task.Payload) != `{"value":1}` {
+		t.Errorf("replayed task %+v", task)
+	}
+
+	// New tasks are numbered after the replayed ones
+	pushAll(t, q, "e")
+	recs := logLines(t, path)
+	if last := recs[len(recs)-1]; last.ID != "e" || last.Seq <= recs[0].Seq {
+		t.Errorf("log %+v, want e sequenced after the replayed tasks", recs)
+	}
+}
+
+func TestWALQueueSkipsTornLastRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.wal")
+
+	q := openTestWAL(t, path)
+	pushAll(t, q, "a", "b")
+	q.Close()
+
+	// A crash mid-write leaves half a record without its newline
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"enqueue","id":"c","seq":3,"ty`)
+	f.Close()
+
+	q = openTestWAL(t, path)
+	if got := drainIDs(q); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("replayed %v, want [a b]", got)
+	}
+
+	// Opening rewrote the log, so new records don't follow the torn one
+	pushAll(t, q, "d")
+	if recs := logLines(t, path); len(recs) != 3 || recs[2].ID != "d" {
+		t.Errorf("log after reopen %+v, want a, b, d", recs)
+	}
+}
+
+func TestWALQueueCompactsAckedTasks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.wal")
+	q := openTestWAL(t, path)
+	q.compactAfter = 4
+
+	pushAll(t, q, "keep", "t1", "t2", "t3")
+	drainIDs(q)
+	for _, id := range []string{"t1", "t2", "t3"} {
+		if err := q.Ack(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(logLines(t, path)); n != 7 {
+		t.Fatalf("%d log records before the threshold, want 7", n)
+	}
+
+	// The fourth ack crosses the threshold and outnumbers pending tasks
+	// more than twice: the log keeps only the pending one
+	pushAll(t, q, "t4")
+	drainIDs(q)
+	if err := q.Ack("t4"); err != nil {
+		t.Fatal(err)
+	}
+	recs := logLines(t, path)
+	if len(recs) != 1 || recs[0].ID != "keep" || recs[0].Op != "enqueue" {
+		t.Fatalf("compacted log %+v, want only keep", recs)
+	}
+
+	// The queue keeps appending to the compacted log
+	pushAll(t, q, "t5")
+	q.Close()
+	q = openTestWAL(t, path)
+	if got := drainIDs(q); !slices.Equal(got, []string{"keep", "t5"}) {
+		t.Errorf("replayed %v after compaction, want [keep t5]", got)
+	}
+}
+
+func TestWALQueueRetractsTimedOutPush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.wal")
+	q, err := OpenWALQueue(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	pushAll(t, q, "a")
+	if err := q.Push(Task{ID: "b"}, 10*time.Millisecond); err != errQueueFull {
+		t.Fatalf("Push to a full queue = %v, want errQueueFull", err)
+	}
+	q.Close()
+
+	q = openTestWAL(t, path)
+	if got := drainIDs(q); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("replayed %v, want the rejected task dropped", got)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// TASK TYPE REGISTRY - Typed payloads validated before queuing
+// ============================================================================
+
+// Payload structs declare their rules in a `validate` tag, comma separated:
+//
+//	required     key must be present and non-null
+//	min=N,max=N  numeric bounds, or length bounds for strings and slices
+//	oneof=a|b    string must be one of the listed values
+//
+// The same tags drive both validation and the published JSON Schema.
+
+type ComputePayload struct {
+	Value     int    `json:"value" validate:"required,min=0,max=1000000"`
+	Operation string `json:"operation,omitempty" validate:"oneof=square|double|negate"`
+}
+
+type DatabasePayload struct {
+	Table string `json:"table" validate:"required,min=1,max=64"`
+	Limit int    `json:"limit,omitempty" validate:"min=1,max=1000"`
+}
+
+type ExternalAPIPayload struct {
+	Endpoint  string `json:"endpoint" validate:"required,min=1,max=256"`
+	TimeoutMs int    `json:"timeout_ms,omitempty" validate:"min=1,max=5000"` // per call; the request's 5s still applies
+}
+
+// FieldError describes one rule a payload field failed.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a request fails schema validation.
+type ValidationError struct {
+	TaskType string       `json:"task_type"`
+	Fields   []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return fmt.Sprintf("invalid %s payload: %s", e.TaskType, strings.Join(parts, "; "))
+}
+
+type TaskType struct {
+	Name        string
+	Description string
+	payloadType reflect.Type
+	schema      map[string]interface{}
+}
+
+type TaskRegistry struct {
+	types map[string]*TaskType
+}
+
+func NewTaskRegistry() *TaskRegistry {
+	return &TaskRegistry{types: make(map[string]*TaskType)}
+}
+
+// DefaultTaskRegistry registers the task types processTask knows about.
+func DefaultTaskRegistry() *TaskRegistry {
+	r := NewTaskRegistry()
+	r.Register("compute", "CPU-bound computation on a single value", ComputePayload{})
+	r.Register("database", "Read up to limit rows from a table", DatabasePayload{})
+	r.Register("external_api", "Call a downstream HTTP endpoint", ExternalAPIPayload{})
+	return r
+}
+
+// Register adds a task type whose payload decodes into the struct type of
+// example. It panics on a malformed validate tag, which is a programming
+// error caught at startup.
+func (r *TaskRegistry) Register(name, description string, example interface{}) {
+	t := reflect.TypeOf(example)
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("task type %s: payload must be a struct, got %s", name, t))
+	}
+	r.types[name] = &TaskType{
+		Name:        name,
+		Description: description,
+		payloadType: t,
+		schema:      buildSchema(t),
+	}
+}
+
+// Decode validates raw against the schema for taskType and returns a
+// pointer to the decoded payload struct. Validation failures are reported
+// as *ValidationError.
+func (r *TaskRegistry) Decode(taskType string, raw json.RawMessage) (interface{}, error) {
+	tt, ok := r.types[taskType]
+	if !ok {
+		return nil, &ValidationError{TaskType: taskType, Fields: []FieldError{{
+			Field:   "task_type",
+			Rule:    "registered",
+			Message: fmt.Sprintf("unknown task type %q", taskType),
+		}}}
+	}
+
+	if len(bytes.TrimSpace(raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		raw = json.RawMessage("{}")
+	}
+
+	// Which keys were sent, so "required" can tell absent from zero
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &present); err != nil {
+		return nil, &ValidationError{TaskType: taskType, Fields: []FieldError{{
+			Field: "payload", Rule: "type", Message: "payload must be a JSON object",
+		}}}
+	}
+
+	payload := reflect.New(tt.payloadType)
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(payload.Interface()); err != nil {
+		return nil, &ValidationError{TaskType: taskType, Fields: []FieldError{decodeFieldError(err)}}
+	}
+
+	if errs := validateStruct(payload.Elem(), present); len(errs) > 0 {
+		return nil, &ValidationError{TaskType: taskType, Fields: errs}
+	}
+	return payload.Interface(), nil
+}
+
+// Types lists registered task types with their JSON Schemas, sorted by name.
+func (r *TaskRegistry) Types() []map[string]interface{} {
+	names := make([]string, 0, len(r.types))
+	for name := range r.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		tt := r.types[name]
+		out = append(out, map[string]interface{}{
+			"name":        tt.Name,
+			"description": tt.Description,
+			"schema":      tt.schema,
+		})
+	}
+	return out
+}
+
+func decodeFieldError(err error) FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return FieldError{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value),
+		}
+	}
+	// encoding/json reports unknown keys as: json: unknown field "name"
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		field := strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`)
+		return FieldError{Field: field, Rule: "unknown", Message: "field is not allowed"}
+	}
+	return FieldError{Field: "payload", Rule: "syntax", Message: err.Error()}
+}
+
+// ----------------------------------------------------------------------------
+// validate tag parsing shared by validation and schema generation
+// ----------------------------------------------------------------------------
+
+type fieldRules struct {
+	name     string
+	required bool
+	min, max *float64
+	oneOf    []string
+}
+
+func parseRules(f reflect.StructField) (fieldRules, bool) {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" || !f.IsExported() {
+		return fieldRules{}, false
+	}
+	if name == "" {
+		name = f.Name
+	}
+
+	rules := fieldRules{name: name}
+	tag := f.Tag.Get("validate")
+	if tag == "" {
+		return rules, true
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		key, arg, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+			rules.required = true
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("field %s: bad %s rule %q", f.Name, key, arg))
+			}
+			if key == "min" {
+				rules.min = &n
+			} else {
+				rules.max = &n
+			}
+		case "oneof":
+			rules.oneOf = strings.Split(arg, "|")
+		default:
+			panic(fmt.Sprintf("field %s: unknown validate rule %q", f.Name, key))
+		}
+	}
+	return rules, true
+}
+
+func validateStruct(v reflect.Value, present map[string]json.RawMessage) []FieldError {
+	var errs []FieldError
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		rules, ok := parseRules(t.Field(i))
+		if !ok {
+			continue
+		}
+
+		raw, sent := present[rules.name]
+		if !sent || string(raw) == "null" {
+			if rules.required {
+				errs = append(errs, FieldError{Field: rules.name, Rule: "required", Message: "field is required"})
+			}
+			continue
+		}
+
+		fv := v.Field(i)
+		var size float64
+		var unit string
+		switch fv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			size = float64(fv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			size = float64(fv.Uint())
+		case reflect.Float32, reflect.Float64:
+			size = fv.Float()
+		case reflect.String, reflect.Slice, reflect.Map:
+			size, unit = float64(fv.Len()), "length "
+		}
+
+		if rules.min != nil && size < *rules.min {
+			errs = append(errs, FieldError{Field: rules.name, Rule: "min",
+				Message: fmt.Sprintf("%smust be at least %v", unit, *rules.min)})
+		}
+		if rules.max != nil && size > *rules.max {
+			errs = append(errs, FieldError{Field: rules.name, Rule: "max",
+				Message: fmt.Sprintf("%smust be at most %v", unit, *rules.max)})
+		}
+		if len(rules.oneOf) > 0 && fv.Kind() == reflect.String {
+			found := false
+			for _, allowed := range rules.oneOf {
+				if fv.String() == allowed {
+					found = true
+					break
+				}
+			}
+			if !found {
+				errs = append(errs, FieldError{Field: rules.name, Rule: "oneof",
+					Message: "must be one of " + strings.Join(rules.oneOf, ", ")})
+			}
+		}
+	}
+	return errs
+}
+
+func buildSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		rules, ok := parseRules(f)
+		if !ok {
+			continue
+		}
+
+		prop := map[string]interface{}{}
+		minKey, maxKey := "minimum", "maximum"
+		switch f.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			prop["type"] = "integer"
+		case reflect.Float32, reflect.Float64:
+			prop["type"] = "number"
+		case reflect.Bool:
+			prop["type"] = "boolean"
+		case reflect.String:
+			prop["type"] = "string"
+			minKey, maxKey = "minLength", "maxLength"
+		case reflect.Slice:
+			prop["type"] = "array"
+			minKey, maxKey = "minItems", "maxItems"
+		default:
+			prop["type"] = "object"
+		}
+
+		if rules.min != nil {
+			prop[minKey] = *rules.min
+		}
+		if rules.max != nil {
+			prop[maxKey] = *rules.max
+		}
+		if len(rules.oneOf) > 0 {
+			prop["enum"] = rules.oneOf
+		}
+		if rules.required {
+			required = append(required, rules.name)
+		}
+		properties[rules.name] = prop
+	}
+
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
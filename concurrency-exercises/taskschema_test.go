@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestTaskRegistryDecode(t *testing.T) {
+	r := DefaultTaskRegistry()
+
+	tests := []struct {
+		name      string
+		taskType  string
+		payload   string
+		wantField string // "" for a valid payload
+		wantRule  string
+	}{
+		{name: "valid compute", taskType: "compute", payload: `{"value":3,"operation":"square"}`},
+		{name: "zero is present", taskType: "compute", payload: `{"value":0}`},
+		{name: "required missing", taskType: "compute", payload: `{"operation":"double"}`, wantField: "value", wantRule: "required"},
+		{name: "required null", taskType: "compute", payload: `{"value":null}`, wantField: "value", wantRule: "required"},
+		{name: "null payload", taskType: "compute", payload: `null`, wantField: "value", wantRule: "required"},
+		{name: "below min", taskType: "compute", payload: `{"value":-1}`, wantField: "value", wantRule: "min"},
+		{name: "above max", taskType: "compute", payload: `{"value":1000001}`, wantField: "value", wantRule: "max"},
+		{name: "oneof", taskType: "compute", payload: `{"value":1,"operation":"cube"}`, wantField: "operation", wantRule: "oneof"},
+		{name: "string length min", taskType: "database", payload: `{"table":""}`, wantField: "table", wantRule: "min"},
+		{name: "optional absent", taskType: "database", payload: `{"table":"orders"}`},
+		{name: "optional out of range", taskType: "database", payload: `{"table":"orders","limit":0}`, wantField: "limit", wantRule: "min"},
+		{name: "unknown field", taskType: "compute", payload: `{"value":1,"extra":true}`, wantField: "extra", wantRule: "unknown"},
+		{name: "wrong type", taskType: "external_api", payload: `{"endpoint":5}`, wantField: "endpoint", wantRule: "type"},
+		{name: "not an object", taskType: "compute", payload: `[1]`, wantField: "payload", wantRule: "type"},
+		{name: "unknown task type", taskType: "shell", payload: `{}`, wantField: "task_type", wantRule: "registered"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Decode(tt.taskType, json.RawMessage(tt.payload))
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("Decode = %v", err)
+				}
+				if got == nil {
+					t.Fatal("Decode returned no payload")
+				}
+				return
+			}
+
+			var vErr *ValidationError
+			if !errors.As(err, &vErr) {
+				t.Fatalf("Decode = %v, want a ValidationError", err)
+			}
+			for _, f := range vErr.Fields {
+				if f.Field == tt.wantField && f.Rule == tt.wantRule {
+					return
+				}
+			}
+			t.Errorf("fields %+v, want %s failing %s", vErr.Fields, tt.wantField, tt.wantRule)
+		})
+	}
+}
+
+func TestTaskRegistryDecodesTypedPayload(t *testing.T) {
+	got, err := DefaultTaskRegistry().Decode("external_api", json.RawMessage(`{"endpoint":"/v1/x","timeout_ms":250}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := got.(*ExternalAPIPayload)
+	if !ok || p.Endpoint != "/v1/x" || p.TimeoutMs != 250 {
+		t.Errorf("Decode = %#v", got)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+type delayed[T any] struct {
+	value T
+	due   time.Time
+}
+
+// delayHeap is a min-heap on due time
+type delayHeap[T any] []delayed[T]
+
+func (h delayHeap[T]) Len() int           { return len(h) }
+func (h delayHeap[T]) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h delayHeap[T]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *delayHeap[T]) Push(x interface{}) { *h = append(*h, x.(delayed[T])) }
+
+func (h *delayHeap[T]) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// delayQueue holds values until they are due and releases them in due
+// order. One goroutine and one timer serve every pending value, however
+// many there are.
+type delayQueue[T any] struct {
+	mu    sync.Mutex
+	items delayHeap[T]
+	wake  chan struct{}
+
+	// onChange, if set, is called under mu with the pending values after
+	// each schedule and each successful release
+	onChange func(pending []delayed[T])
+}
+
+func newDelayQueue[T any]() *delayQueue[T] {
+	return &delayQueue[T]{wake: make(chan struct{}, 1)}
+}
+
+// schedule queues v to be released at due
+func (q *delayQueue[T]) schedule(v T, due time.Time) {
+	q.mu.Lock()
+	heap.Push(&q.items, delayed[T]{value: v, due: due})
+	q.changedLocked()
+	q.mu.Unlock()
+
+	// Nudge run in case this is now the earliest value
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *delayQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *delayQueue[T]) changedLocked() {
+	if q.onChange != nil {
+		q.onChange(q.items)
+	}
+}
+
+// run hands due values to release until ctx is done or stop is closed;
+// stop may be nil. release returning false means its consumer is gone:
+// the value is put back and run returns. Values not yet due stay queued.
+func (q *delayQueue[T]) run(ctx context.Context, stop <-chan struct{}, release func(T) bool) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		q.mu.Lock()
+		wait := time.Hour
+		if len(q.items) > 0 {
+			wait = time.Until(q.items[0].due)
+		}
+		if wait <= 0 {
+			next := heap.Pop(&q.items).(delayed[T])
+			q.mu.Unlock()
+
+			if !release(next.value) {
+				q.schedule(next.value, next.due)
+				return
+			}
+
+			q.mu.Lock()
+			q.changedLocked()
+			q.mu.Unlock()
+			continue
+		}
+		q.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// drain removes and returns every pending value
+func (q *delayQueue[T]) drain() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	values := make([]T, len(q.items))
+	for i, item := range q.items {
+		values[i] = item.value
+	}
+	q.items = nil
+	q.changedLocked()
+	return values
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDelayQueueReleasesInDueOrder(t *testing.T) {
+	q := newDelayQueue[int]()
+	var persisted []int
+	q.onChange = func(pending []delayed[int]) { persisted = append(persisted, len(pending)) }
+
+	now := time.Now()
+	q.schedule(3, now.Add(30*time.Millisecond))
+	q.schedule(1, now.Add(10*time.Millisecond))
+	q.schedule(2, now.Add(20*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// The consumer goes away after two values; the third must be put back
+	var got []int
+	q.run(ctx, nil, func(v int) bool {
+		if len(got) == 2 {
+			return false
+		}
+		got = append(got, v)
+		return true
+	})
+
+	if !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("released %v, want [1 2]", got)
+	}
+	if left := q.drain(); !slices.Equal(left, []int{3}) {
+		t.Errorf("drained %v, want [3]", left)
+	}
+	// Three schedules, two releases, the put-back and the drain
+	if want := []int{1, 2, 3, 2, 1, 1, 0}; !slices.Equal(persisted, want) {
+		t.Errorf("onChange saw %v, want %v", persisted, want)
+	}
+}
+
+func TestDelayQueueRunStops(t *testing.T) {
+	q := newDelayQueue[int]()
+	q.schedule(1, time.Now().Add(time.Hour))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		q.run(context.Background(), stop, func(int) bool { return true })
+		close(done)
+	}()
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run did not return after stop closed")
+	}
+	if q.Len() != 1 {
+		t.Errorf("Len = %d, want the undue value kept", q.Len())
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
+
+	"interview/pipeline"
 )
 
 // DataRecord represents raw input data
@@ -22,9 +28,59 @@ type ProcessedData struct {
 
 // AggregatedResult represents final aggregated output
 type AggregatedResult struct {
-	TotalRecords int
-	Sum          float64
-	Average      float64
+	TotalRecords    int
+	Sum             float64
+	Average         float64
+	FailedCount     int
+	FailedIDs       []int          // sorted
+	ErrorCategories map[string]int // failures per category
+}
+
+// RecordError is why one record didn't make it through the pipeline
+type RecordError struct {
+	ID       int
+	Attempts int
+	Category string // "fetch", "timeout" or "cancelled"
+	Err      error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record %d: %s after %d attempts: %v", e.ID, e.Category, e.Attempts, e.Err)
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+func categorize(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	default:
+		return "fetch"
+	}
+}
+
+// addFailure records a failed record in the result
+func (r *AggregatedResult) addFailure(err error) {
+	r.FailedCount++
+	var recErr *RecordError
+	if !errors.As(err, &recErr) {
+		recErr = &RecordError{Category: categorize(err), Err: err}
+	}
+	r.FailedIDs = append(r.FailedIDs, recErr.ID)
+	if r.ErrorCategories == nil {
+		r.ErrorCategories = make(map[string]int)
+	}
+	r.ErrorCategories[recErr.Category]++
+}
+
+// finish computes the average and sorts failed IDs
+func (r *AggregatedResult) finish() {
+	if r.TotalRecords > 0 {
+		r.Average = r.Sum / float64(r.TotalRecords)
+	}
+	sort.Ints(r.FailedIDs)
 }
 
 // fetchData simulates reading data from a source (database, file, API, etc.)
@@ -56,6 +112,111 @@ func processRecord(record *DataRecord, processorID int) *ProcessedData {
 	}
 }
 
+// fetchAttempts bounds retries of transient fetch errors
+const fetchAttempts = 3
+
+// fetchAttemptTimeout bounds one fetch call; a slow attempt is retried
+const fetchAttemptTimeout = 500 * time.Millisecond
+
+// TransientError marks a fetch failure worth retrying
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string { return "transient: " + e.Err.Error() }
+
+func (e *TransientError) Unwrap() error { return e.Err }
+
+func isTransient(err error) bool {
+	var t *TransientError
+	return errors.As(err, &t)
+}
+
+// flakyFetch is fetchData behind a simulated unreliable connection: 10%
+// of calls fail with a transient error before reaching the source
+func flakyFetch(recordID int) (*DataRecord, error) {
+	if rand.Intn(10) == 0 {
+		time.Sleep(5 * time.Millisecond)
+		return nil, &TransientError{Err: fmt.Errorf("fetch of record %d: connection reset", recordID)}
+	}
+	return fetchData(recordID)
+}
+
+// recordFetcher fetches records with retries. fetch marks retryable
+// failures by returning a TransientError; any other error is permanent.
+type recordFetcher struct {
+	fetch    func(recordID int) (*DataRecord, error)
+	attempts int
+	timeout  time.Duration // per attempt
+	backoff  time.Duration // before retry n: backoff<<n, plus up to half again of jitter
+}
+
+var defaultFetcher = recordFetcher{
+	fetch:    flakyFetch,
+	attempts: fetchAttempts,
+	timeout:  fetchAttemptTimeout,
+	backoff:  10 * time.Millisecond,
+}
+
+// fetchWithRetry fetches one record through defaultFetcher
+func fetchWithRetry(ctx context.Context, recordID int) (*DataRecord, error) {
+	return defaultFetcher.get(ctx, recordID)
+}
+
+// once makes one fetch call. An attempt that runs past the timeout is
+// transient. fetch takes no context and can't be interrupted, so the
+// abandoned call finishes in the background: at most one goroutine per
+// timed-out attempt, each gone once fetch returns.
+func (f *recordFetcher) once(ctx context.Context, recordID int) (*DataRecord, error) {
+	type reply struct {
+		rec *DataRecord
+		err error
+	}
+	done := make(chan reply, 1) // buffered, so an abandoned call never blocks
+	go func() {
+		rec, err := f.fetch(recordID)
+		done <- reply{rec, err}
+	}()
+
+	timer := time.NewTimer(f.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.rec, r.err
+	case <-timer.C:
+		return nil, &TransientError{Err: fmt.Errorf("fetch of record %d: %w", recordID, context.DeadlineExceeded)}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// get retries transient fetch errors with exponential backoff and
+// jitter. Permanent errors and cancellation are reported at once.
+func (f *recordFetcher) get(ctx context.Context, recordID int) (*DataRecord, error) {
+	for attempt := 1; ; attempt++ {
+		rec, err := f.once(ctx, recordID)
+		if err == nil {
+			return rec, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, &RecordError{ID: recordID, Attempts: attempt, Category: categorize(ctxErr), Err: ctxErr}
+		}
+		if !isTransient(err) || attempt >= f.attempts {
+			return nil, &RecordError{ID: recordID, Attempts: attempt, Category: categorize(err), Err: err}
+		}
+
+		backoff := f.backoff << attempt
+		if backoff > 0 {
+			backoff += time.Duration(rand.Int63n(int64(backoff/2) + 1))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, &RecordError{ID: recordID, Attempts: attempt, Category: categorize(ctx.Err()), Err: ctx.Err()}
+		case <-time.After(backoff):
+		}
+	}
+}
+
 // TODO: Implement this function (FAN-OUT)
 // Read records and fan them out to multiple channels for parallel processing
 // Should:
@@ -63,32 +224,133 @@ func processRecord(record *DataRecord, processorID int) *ProcessedData {
 // - Send successfully fetched records to the output channel
 // - Close the channel when done
 // - Handle fetch errors gracefully (skip failed records)
-func dataSource(numRecords int, maxConcurrency int) <-chan *DataRecord {
+//
+// Records that still fail after retries are reported on the second
+// channel, which closes together with the first; read both. Exactly
+// maxConcurrency fetch goroutines run, and all of them exit once ctx is
+// done, so a consumer that stops reading just cancels.
+func dataSource(ctx context.Context, numRecords int, maxConcurrency int) (<-chan *DataRecord, <-chan *RecordError) {
 	// Your implementation here
 	out := make(chan *DataRecord)
+	errs := make(chan *RecordError)
+	ids := make(chan int)
 	var wg sync.WaitGroup
-	sema := make(chan struct{}, maxConcurrency)
 
-	for i := 1; i <= numRecords; i++ {
+	go func() {
+		defer close(ids)
+		for id := 1; id <= numRecords; id++ {
+			select {
+			case ids <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < max(maxConcurrency, 1); i++ {
 		wg.Add(1)
-		go func(id int) {
+		go func() {
 			defer wg.Done()
-			sema <- struct{}{}
-			defer func() { <-sema }()
-			rec, err := fetchData(id)
-			if err == nil {
-				out <- rec
+			for id := range ids {
+				rec, err := fetchWithRetry(ctx, id)
+				if err != nil {
+					select {
+					case errs <- err.(*RecordError):
+					case <-ctx.Done():
+						return
+					}
+					continue
+				}
+				select {
+				case out <- rec:
+				case <-ctx.Done():
+					return
+				}
 			}
-
-		}(i)
+		}()
 	}
 
 	go func() {
 		wg.Wait()
 		close(out)
+		close(errs)
 	}()
 
-	return out
+	return out, errs
+}
+
+// orderedDataSource is dataSource with records and failures delivered in
+// ID order, so every processor reading from it sees its records in order
+// too. Fetches still run maxConcurrency at a time; a slow one holds back
+// delivery of those after it.
+func orderedDataSource(ctx context.Context, numRecords int, maxConcurrency int) (<-chan *DataRecord, <-chan *RecordError) {
+	type fetch struct {
+		id   int
+		rec  *DataRecord
+		err  error
+		done chan struct{}
+	}
+
+	out := make(chan *DataRecord)
+	errs := make(chan *RecordError)
+	work := make(chan *fetch)
+	// Fetches in ID order; the buffer bounds how far fetching runs ahead
+	// of the oldest undelivered record
+	order := make(chan *fetch, max(maxConcurrency, 1))
+
+	go func() {
+		defer close(work)
+		defer close(order)
+		for id := 1; id <= numRecords; id++ {
+			f := &fetch{id: id, done: make(chan struct{})}
+			select {
+			case order <- f:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case work <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < max(maxConcurrency, 1); i++ {
+		go func() {
+			for f := range work {
+				f.rec, f.err = fetchWithRetry(ctx, f.id)
+				close(f.done)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(out)
+		defer close(errs)
+		for f := range order {
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return
+			}
+			if f.err != nil {
+				select {
+				case errs <- f.err.(*RecordError):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			select {
+			case out <- f.rec:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, errs
 }
 
 // TODO: Implement this function (PROCESSING STAGE)
@@ -98,13 +360,29 @@ func dataSource(numRecords int, maxConcurrency int) <-chan *DataRecord {
 // - Process each record
 // - Send processed data to output channel
 // - Close output channel when input is closed
-func processor(processorID int, input <-chan *DataRecord) <-chan *ProcessedData {
+// - Stop once ctx is done, after the record in hand
+func processor(ctx context.Context, processorID int, input <-chan *DataRecord) <-chan *ProcessedData {
 	out := make(chan *ProcessedData)
 
 	go func() {
 		defer close(out)
-		for record := range input {
-			out <- processRecord(record, processorID)
+		for {
+			var record *DataRecord
+			var ok bool
+			select {
+			case record, ok = <-input:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case out <- processRecord(record, processorID):
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
@@ -117,7 +395,8 @@ func processor(processorID int, input <-chan *DataRecord) <-chan *ProcessedData
 // - Read from all input channels concurrently
 // - Send all data to single output channel
 // - Close output channel when ALL inputs are closed
-func merge(channels ...<-chan *ProcessedData) <-chan *ProcessedData {
+// - Stop early once ctx is done
+func merge(ctx context.Context, channels ...<-chan *ProcessedData) <-chan *ProcessedData {
 	// Your implementation here
 	out := make(chan *ProcessedData)
 	var wg sync.WaitGroup
@@ -127,7 +406,11 @@ func merge(channels ...<-chan *ProcessedData) <-chan *ProcessedData {
 		go func(c <-chan *ProcessedData) {
 			defer wg.Done()
 			for data := range c {
-				out <- data
+				select {
+				case out <- data:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}(ch)
 	}
@@ -138,53 +421,211 @@ func merge(channels ...<-chan *ProcessedData) <-chan *ProcessedData {
 	return out
 }
 
+// reorderWindow is how far ahead of the next ID an ordered merge buffers
+const reorderWindow = 16
+
+// mergeOrdered is merge with output in ID order. Each channel must carry
+// its own records in ID order, as processors fed by orderedDataSource do.
+// IDs missing because their fetch failed are skipped once every
+// processor has moved past them.
+func mergeOrdered(ctx context.Context, window int, channels ...<-chan *ProcessedData) <-chan *ProcessedData {
+	p := pipeline.New(ctx)
+	out := pipeline.MergeOrdered(p, func(d *ProcessedData) int { return d.ID }, 1, window, nil, channels...)
+	go p.Wait() // release the pipeline once the merge drains
+	return out
+}
+
 // TODO: Implement this function (AGGREGATOR)
 // Aggregate all processed data into final result
 // Should:
 // - Read from input channel until closed
 // - Calculate total records, sum, and average
-func aggregate(input <-chan *ProcessedData) *AggregatedResult {
+// - Count failures from the failures channel, read until it closes too
+func aggregate(input <-chan *ProcessedData, failures <-chan *RecordError) *AggregatedResult {
 	// Your implementation here
-	var total int
-	var sum float64
-	for data := range input {
-		total++
-		sum += data.ProcessedValue
+	result := &AggregatedResult{}
+	for input != nil || failures != nil {
+		select {
+		case data, ok := <-input:
+			if !ok {
+				input = nil
+				continue
+			}
+			result.TotalRecords++
+			result.Sum += data.ProcessedValue
+		case err, ok := <-failures:
+			if !ok {
+				failures = nil
+				continue
+			}
+			result.addFailure(err)
+		}
+	}
+
+	result.finish()
+	return result
+}
+
+// runPipeline is the same fan-out/fan-in flow built on the pipeline
+// package. With ordered set, results reach the aggregator in ID order.
+func runPipeline(ctx context.Context, numRecords, numProcessors, maxConcurrency int, ordered bool) (*AggregatedResult, error) {
+	p := pipeline.New(ctx)
+	merged := processStream(p, numRecords, numProcessors, maxConcurrency, ordered)
+
+	result, err := pipeline.Reduce(p, merged, AggregatedResult{},
+		func(acc AggregatedResult, item pipeline.Item[*ProcessedData]) AggregatedResult {
+			if item.Err != nil {
+				acc.addFailure(item.Err)
+				return acc
+			}
+			acc.TotalRecords++
+			acc.Sum += item.Value.ProcessedValue
+			return acc
+		})
+	result.finish()
+	return &result, err
+}
+
+// processStream builds the fetch, fan-out, process and merge stages on p
+func processStream(p *pipeline.Pipeline, numRecords, numProcessors, maxConcurrency int, ordered bool) <-chan pipeline.Item[*ProcessedData] {
+	ids := pipeline.Source(p, func(_ context.Context, emit func(int) bool) error {
+		for id := 1; id <= numRecords && emit(id); id++ {
+		}
+		return nil
+	})
+	// Failed fetches travel downstream as errors rather than being dropped
+	fetch := func(ctx context.Context, id int) (pipeline.Item[*DataRecord], error) {
+		rec, err := fetchWithRetry(ctx, id)
+		return pipeline.Item[*DataRecord]{Value: rec, Err: err}, nil
+	}
+
+	var records <-chan pipeline.Item[*DataRecord]
+	if ordered {
+		// MergeOrdered needs every input in ID order, which a Map with
+		// several workers doesn't keep: fetch on single-worker shards
+		// of the ordered IDs and merge those back in order
+		shards := pipeline.FanOut(p, ids, maxConcurrency)
+		fetched := make([]<-chan pipeline.Item[*DataRecord], len(shards))
+		for i, shard := range shards {
+			fetched[i] = pipeline.Map(p, shard, 1, fetch)
+		}
+		records = pipeline.MergeOrdered(p, fetchedID, 1, reorderWindow, nil, fetched...)
+	} else {
+		records = pipeline.Map(p, ids, maxConcurrency, fetch)
+	}
+
+	shards := pipeline.FanOut(p, records, numProcessors)
+	processed := make([]<-chan pipeline.Item[*ProcessedData], len(shards))
+	for i, shard := range shards {
+		processed[i] = pipeline.MapItems(p, shard, 1, func(_ context.Context, rec *DataRecord) (*ProcessedData, error) {
+			return processRecord(rec, i+1), nil
+		})
+	}
+
+	if ordered {
+		// Each single-worker shard of the ordered records is in order too.
+		// Failed records are items, so the sequence has no gaps.
+		return pipeline.MergeOrdered(p, recordID, 1, reorderWindow, nil, processed...)
+	}
+	return pipeline.Merge(p, processed...)
+}
+
+// runWindowed streams processed values through a windowed aggregator
+// grouped by processor, printing each window as it closes and a live
+// partial every second
+func runWindowed(ctx context.Context, numRecords, numProcessors, maxConcurrency int, window pipeline.Window) error {
+	p := pipeline.New(ctx)
+	merged := processStream(p, numRecords, numProcessors, maxConcurrency, false)
+
+	succeeded := pipeline.Filter(p, merged, func(item pipeline.Item[*ProcessedData]) bool { return item.Err == nil })
+	windows, agg := pipeline.Aggregate(p, succeeded, window,
+		func(item pipeline.Item[*ProcessedData]) int { return item.Value.ProcessorID },
+		func(item pipeline.Item[*ProcessedData]) float64 { return item.Value.ProcessedValue })
+
+	stopLive := make(chan struct{})
+	liveDone := make(chan struct{})
+	go func() {
+		defer close(liveDone)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopLive:
+				return
+			case <-ticker.C:
+				fmt.Printf("  live: %d records in open window\n", agg.Snapshot().Count())
+			}
+		}
+	}()
+
+	for w := range windows {
+		fmt.Printf("  window of %d (partial=%v):\n", w.Count(), w.Partial)
+		for id := 1; id <= numProcessors; id++ {
+			if s, ok := w.Groups[id]; ok {
+				fmt.Printf("    processor %d: n=%d min=%.0f max=%.0f mean=%.1f var=%.1f p50=%.0f p95=%.0f\n",
+					id, s.Count, s.Min, s.Max, s.Mean(), s.Variance(), s.Percentile(0.5), s.Percentile(0.95))
+			}
+		}
 	}
 
-	avg := 0.0
-	if total > 0 {
-		avg = sum / float64(total)
+	close(stopLive)
+	<-liveDone
+	return p.Wait()
+}
+
+// recordID numbers a pipeline item by record, failed or not
+func recordID(item pipeline.Item[*ProcessedData]) int {
+	var recErr *RecordError
+	if errors.As(item.Err, &recErr) {
+		return recErr.ID
 	}
+	return item.Value.ID
+}
 
-	return &AggregatedResult{
-		TotalRecords: total,
-		Sum:          sum,
-		Average:      avg,
+// fetchedID is recordID for fetched records
+func fetchedID(item pipeline.Item[*DataRecord]) int {
+	var recErr *RecordError
+	if errors.As(item.Err, &recErr) {
+		return recErr.ID
 	}
+	return item.Value.ID
 }
 
 func main_() {
 	numRecords := 100
 	numProcessors := 5
 	maxConcurrency := 1
+	ordered := true
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
 
 	start := time.Now()
 
 	// TODO: Build the pipeline
 	// 1. Create data source (fan-out point)
-	source := dataSource(numRecords, maxConcurrency)
+	// mergeOrdered needs each processor's records in ID order
+	sourceFn := dataSource
+	if ordered {
+		sourceFn = orderedDataSource
+	}
+	source, failures := sourceFn(ctx, numRecords, maxConcurrency)
 	// 2. Create multiple processors
 	var processors []<-chan *ProcessedData
 	for i := 1; i <= numProcessors; i++ {
-		processors = append(processors, processor(i, source))
+		processors = append(processors, processor(ctx, i, source))
 	}
 
 	// 3. Merge processor outputs (fan-in point)
-	merged := merge(processors...)
+	var merged <-chan *ProcessedData
+	if ordered {
+		merged = mergeOrdered(ctx, reorderWindow, processors...)
+	} else {
+		merged = merge(ctx, processors...)
+	}
 
 	// 4. Aggregate final results
-	result := aggregate(merged)
+	result := aggregate(merged, failures)
 
 	// Your pipeline construction here
 
@@ -192,4 +633,22 @@ func main_() {
 	elapsed := time.Since(start)
 	fmt.Printf("Processed %d records in %v\n", result.TotalRecords, elapsed)
 	fmt.Printf("Sum: %.2f, Avg: %.2f\n", result.Sum, result.Average)
+	fmt.Printf("Failed: %d %v by category %v\n", result.FailedCount, result.FailedIDs, result.ErrorCategories)
+
+	// Same flow on the pipeline package
+	start = time.Now()
+	result, err := runPipeline(ctx, numRecords, numProcessors, maxConcurrency, ordered)
+	if err != nil {
+		fmt.Printf("Pipeline failed: %v\n", err)
+		return
+	}
+	fmt.Printf("Pipeline processed %d records in %v\n", result.TotalRecords, time.Since(start))
+	fmt.Printf("Sum: %.2f, Avg: %.2f\n", result.Sum, result.Average)
+	fmt.Printf("Failed: %d %v by category %v\n", result.FailedCount, result.FailedIDs, result.ErrorCategories)
+
+	// Streaming stats over sliding windows of 40 records, every 20
+	fmt.Println("Windowed aggregation:")
+	if err := runWindowed(ctx, numRecords, numProcessors, maxConcurrency, pipeline.CountWindow(40, 20)); err != nil {
+		fmt.Printf("Windowed pipeline failed: %v\n", err)
+	}
 }
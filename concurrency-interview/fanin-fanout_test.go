@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"interview/pipeline"
+)
+
+// waitForGoroutines polls until at most want goroutines are running, and
+// reports the final count
+func waitForGoroutines(want int, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for runtime.NumGoroutine() > want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
+
+func TestPipelineNoGoroutineLeakOnCancel(t *testing.T) {
+	const numRecords, numProcessors, maxConcurrency = 100, 5, 4
+
+	merges := map[string]struct {
+		source func(context.Context, int, int) (<-chan *DataRecord, <-chan *RecordError)
+		merge  func(context.Context, ...<-chan *ProcessedData) <-chan *ProcessedData
+	}{
+		"merge": {dataSource, merge},
+		"mergeOrdered": {orderedDataSource, func(ctx context.Context, chs ...<-chan *ProcessedData) <-chan *ProcessedData {
+			return mergeOrdered(ctx, reorderWindow, chs...)
+		}},
+	}
+
+	for name, tt := range merges {
+		t.Run(name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// failures is deliberately never read: a source blocked on
+			// reporting one must still exit on cancel
+			source, _ := tt.source(ctx, numRecords, maxConcurrency)
+			var processors []<-chan *ProcessedData
+			for i := 1; i <= numProcessors; i++ {
+				processors = append(processors, processor(ctx, i, source))
+			}
+			merged := tt.merge(ctx, processors...)
+
+			for i := 0; i < 5; i++ {
+				if _, ok := <-merged; !ok {
+					t.Fatalf("merged closed after %d results", i)
+				}
+			}
+			if runtime.NumGoroutine() <= before {
+				t.Fatal("pipeline started no goroutines")
+			}
+
+			cancel()
+			for range merged {
+			}
+
+			// Stages finish the record in hand, at most a fetch with its
+			// backoff or a process call
+			if after := waitForGoroutines(before, 2*time.Second); after > before {
+				buf := make([]byte, 1<<16)
+				t.Fatalf("%d goroutines still running after cancel, want %d\n%s",
+					after, before, buf[:runtime.Stack(buf, true)])
+			}
+		})
+	}
+}
+
+// scriptedFetch fails with the given errors in turn, then succeeds, and
+// records when each call was made
+type scriptedFetch struct {
+	mu    sync.Mutex
+	errs  []error
+	delay time.Duration
+	calls []time.Time
+}
+
+func (s *scriptedFetch) fetch(recordID int) (*DataRecord, error) {
+	s.mu.Lock()
+	n := len(s.calls)
+	s.calls = append(s.calls, time.Now())
+	s.mu.Unlock()
+
+	time.Sleep(s.delay)
+	if n < len(s.errs) {
+		return nil, s.errs[n]
+	}
+	return &DataRecord{ID: recordID}, nil
+}
+
+func (s *scriptedFetch) callTimes() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.calls)
+}
+
+func TestFetcherRetriesOnlyTransientErrors(t *testing.T) {
+	transient := &TransientError{Err: errors.New("connection reset")}
+	permanent := errors.New("no such record")
+	const backoff = 20 * time.Millisecond
+
+	tests := []struct {
+		name         string
+		errs         []error
+		delay        time.Duration
+		wantCalls    int
+		wantErr      bool
+		wantCategory string
+	}{
+		{name: "succeeds first time", wantCalls: 1},
+		{name: "recovers after transient errors", errs: []error{transient, transient}, wantCalls: 3},
+		{name: "gives up after attempts", errs: []error{transient, transient, transient}, wantCalls: 3, wantErr: true, wantCategory: "fetch"},
+		{name: "permanent is not retried", errs: []error{permanent}, wantCalls: 1, wantErr: true, wantCategory: "fetch"},
+		{name: "slow attempt times out and is retried", delay: 80 * time.Millisecond, wantCalls: 3, wantErr: true, wantCategory: "timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := &scriptedFetch{errs: tt.errs, delay: tt.delay}
+			f := recordFetcher{fetch: script.fetch, attempts: 3, timeout: 50 * time.Millisecond, backoff: backoff}
+
+			rec, err := f.get(context.Background(), 7)
+			calls := script.callTimes()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("%d calls, want %d", len(calls), tt.wantCalls)
+			}
+
+			if !tt.wantErr {
+				if err != nil || rec.ID != 7 {
+					t.Fatalf("get = %v, %v", rec, err)
+				}
+			} else {
+				var recErr *RecordError
+				if !errors.As(err, &recErr) {
+					t.Fatalf("get = %v, want a RecordError", err)
+				}
+				if recErr.Attempts != tt.wantCalls || recErr.Category != tt.wantCategory {
+					t.Errorf("%d attempts, category %q; want %d, %q", recErr.Attempts, recErr.Category, tt.wantCalls, tt.wantCategory)
+				}
+			}
+
+			// Retry n waits at least backoff<<n after attempt n ended
+			for n := 1; n < len(calls); n++ {
+				if gap := calls[n].Sub(calls[n-1]); gap < backoff<<n {
+					t.Errorf("retry %d after %v, want at least %v", n, gap, backoff<<n)
+				}
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		script := &scriptedFetch{errs: []error{transient}}
+		f := recordFetcher{fetch: script.fetch, attempts: 3, timeout: time.Second, backoff: time.Hour}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			for len(script.callTimes()) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+		}()
+
+		var recErr *RecordError
+		if _, err := f.get(ctx, 7); !errors.As(err, &recErr) || recErr.Category != "cancelled" {
+			t.Errorf("cancelled fetch = %v, want category cancelled", err)
+		}
+	})
+}
+
+func TestOrderedPipelinesKeepIDOrder(t *testing.T) {
+	const numRecords, numProcessors, maxConcurrency = 60, 5, 4
+
+	// Every ID arrives, failed or not, and none after a higher one
+	checkOrder := func(t *testing.T, ids []int, wantAll bool) {
+		t.Helper()
+		if !slices.IsSorted(ids) || len(slices.Compact(slices.Clone(ids))) != len(ids) {
+			t.Fatalf("IDs out of order: %v", ids)
+		}
+		if wantAll && len(ids) != numRecords {
+			t.Fatalf("%d IDs, want %d", len(ids), numRecords)
+		}
+	}
+
+	t.Run("mergeOrdered", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		source, failures := orderedDataSource(ctx, numRecords, maxConcurrency)
+		var processors []<-chan *ProcessedData
+		for i := 1; i <= numProcessors; i++ {
+			processors = append(processors, processor(ctx, i, source))
+		}
+		merged := mergeOrdered(ctx, reorderWindow, processors...)
+
+		var ids []int
+		done := make(chan int)
+		go func() {
+			n := 0
+			for range failures {
+				n++
+			}
+			done <- n
+		}()
+		for d := range merged {
+			ids = append(ids, d.ID)
+		}
+		failed := <-done
+
+		checkOrder(t, ids, false)
+		if len(ids)+failed != numRecords {
+			t.Errorf("%d processed and %d failed, want %d in all", len(ids), failed, numRecords)
+		}
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		p := pipeline.New(context.Background())
+		merged := processStream(p, numRecords, numProcessors, maxConcurrency, true)
+
+		ids, err := pipeline.Reduce(p, merged, []int(nil), func(acc []int, item pipeline.Item[*ProcessedData]) []int {
+			return append(acc, recordID(item))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkOrder(t, ids, true)
+	})
+}
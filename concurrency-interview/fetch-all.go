@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// FetchOptions configures FetchAll and StreamAll
+type FetchOptions struct {
+	MaxRPS         int           // calls per second, retries included
+	Burst          int           // calls allowed at once above the rate; 0 means MaxRPS
+	MaxConcurrency int           // calls in flight at once; 0 means MaxRPS
+	CallTimeout    time.Duration // per attempt; 0 for none
+	Retry          RetryPolicy   // attempts and backoff per key
+}
+
+// DefaultFetchOptions allows maxRPS calls per second and 3 attempts per
+// key with backoff from 100ms
+func DefaultFetchOptions(maxRPS int) FetchOptions {
+	return FetchOptions{
+		MaxRPS: maxRPS,
+		Retry: RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   100 * time.Millisecond,
+			MaxDelay:    2 * time.Second,
+			Multiplier:  2,
+			Jitter:      0.2,
+		},
+	}
+}
+
+// FetchOutcome is the result of fetching one key
+type FetchOutcome[K comparable, V any] struct {
+	Key      K
+	Value    V
+	Attempts int
+	Err      error
+}
+
+// FetchResult reports every requested key: fetched, or why not
+type FetchResult[K comparable, V any] struct {
+	Values   map[K]V
+	Errors   map[K]error // includes keys never tried because ctx ended
+	Attempts map[K]int
+
+	keys []K
+}
+
+// FailedKeys returns the keys in Errors, in request order
+func (r *FetchResult[K, V]) FailedKeys() []K {
+	var failed []K
+	for _, k := range r.keys {
+		if _, ok := r.Errors[k]; ok {
+			failed = append(failed, k)
+		}
+	}
+	return failed
+}
+
+// FetchError aggregates the per-key failures of a FetchAll call
+type FetchError[K comparable] struct {
+	Total  int
+	Keys   []K // failed keys, in request order
+	Errors map[K]error
+}
+
+func (e *FetchError[K]) Error() string {
+	first := e.Keys[0]
+	return fmt.Sprintf("%d of %d fetches failed; %v: %v", len(e.Keys), e.Total, first, e.Errors[first])
+}
+
+// Unwrap exposes the per-key errors to errors.Is and errors.As
+func (e *FetchError[K]) Unwrap() []error {
+	errs := make([]error, len(e.Keys))
+	for i, k := range e.Keys {
+		errs[i] = e.Errors[k]
+	}
+	return errs
+}
+
+// FetchAll calls fetch for every key concurrently, within the rate and
+// concurrency limits, retrying per opts.Retry. Every key ends up in
+// Values or Errors. The error is ctx.Err() if the context ended first,
+// otherwise a *FetchError if any key failed.
+func FetchAll[K comparable, V any](ctx context.Context, keys []K, fetch func(context.Context, K) (V, error), opts FetchOptions) (*FetchResult[K, V], error) {
+	result := &FetchResult[K, V]{
+		Values:   make(map[K]V),
+		Errors:   make(map[K]error),
+		Attempts: make(map[K]int),
+		keys:     keys,
+	}
+
+	for out := range StreamAll(ctx, keys, fetch, opts) {
+		result.Attempts[out.Key] = out.Attempts
+		if out.Err != nil {
+			result.Errors[out.Key] = out.Err
+		} else {
+			result.Values[out.Key] = out.Value
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		for _, k := range keys {
+			if _, ok := result.Values[k]; !ok && result.Errors[k] == nil {
+				result.Errors[k] = err
+			}
+		}
+		return result, err
+	}
+	if len(result.Errors) > 0 {
+		return result, &FetchError[K]{Total: len(keys), Keys: result.FailedKeys(), Errors: result.Errors}
+	}
+	return result, nil
+}
+
+// StreamAll is FetchAll sending each outcome as it completes. The channel
+// closes once every fetch has finished or ctx is done; outcomes for keys
+// not reached by then are not sent.
+func StreamAll[K comparable, V any](ctx context.Context, keys []K, fetch func(context.Context, K) (V, error), opts FetchOptions) <-chan FetchOutcome[K, V] {
+	out := make(chan FetchOutcome[K, V])
+
+	maxRPS := max(opts.MaxRPS, 1)
+	burst := opts.Burst
+	if burst <= 0 {
+		burst = maxRPS
+	}
+	concurrency := opts.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = maxRPS
+	}
+	limiter := rate.NewLimiter(rate.Limit(maxRPS), burst)
+	sem := make(chan struct{}, concurrency)
+
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(out)
+		}()
+
+		for _, key := range keys {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			wg.Add(1)
+			go func(k K) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				v, attempts, err := callWithRetry(ctx, k, fetch, opts, limiter)
+				select {
+				case out <- FetchOutcome[K, V]{Key: k, Value: v, Attempts: attempts, Err: err}:
+				case <-ctx.Done():
+				}
+			}(key)
+		}
+	}()
+	return out
+}
+
+// callWithRetry fetches one key, waiting on the limiter before every
+// attempt so retries count against the rate limit too. It returns the
+// attempts made.
+func callWithRetry[K comparable, V any](ctx context.Context, key K, fetch func(context.Context, K) (V, error), opts FetchOptions, limiter *rate.Limiter) (V, int, error) {
+	var zero V
+	for attempt := 1; ; attempt++ {
+		if err := limiter.Wait(ctx); err != nil {
+			return zero, attempt - 1, err
+		}
+
+		v, err := callOnce(ctx, key, fetch, opts.CallTimeout)
+		if err == nil {
+			return v, attempt, nil
+		}
+		if ctx.Err() != nil {
+			return zero, attempt, ctx.Err()
+		}
+		if !opts.Retry.ShouldRetry(err, attempt) {
+			if attempt > 1 {
+				err = fmt.Errorf("failed after %d attempts: %w", attempt, err)
+			}
+			return zero, attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return zero, attempt, ctx.Err()
+		case <-time.After(opts.Retry.Backoff(attempt)):
+		}
+	}
+}
+
+// errCallTimeout marks an attempt cut off by FetchOptions.CallTimeout, so
+// it is retried like any other failure
+var errCallTimeout = errors.New("call timed out")
+
+func callOnce[K comparable, V any](ctx context.Context, key K, fetch func(context.Context, K) (V, error), timeout time.Duration) (V, error) {
+	if timeout <= 0 {
+		return fetch(ctx, key)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	v, err := fetch(callCtx, key)
+	if err != nil && ctx.Err() == nil && callCtx.Err() != nil {
+		err = fmt.Errorf("%w after %v: %w", errCallTimeout, timeout, err)
+	}
+	return v, err
+}
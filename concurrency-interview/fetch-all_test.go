@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	errFlaky   = errors.New("flaky")
+	errRefused = errors.New("refused")
+)
+
+func fastFetchOptions() FetchOptions {
+	return FetchOptions{
+		MaxRPS: 1000,
+		Retry: RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			Multiplier:  2,
+			Retryable:   func(err error) bool { return !errors.Is(err, errRefused) },
+		},
+	}
+}
+
+// failingFetch fails each key failures[key] times with errFlaky before
+// succeeding; -1 fails forever, and "refused" fails without retry
+func failingFetch(failures map[string]int) func(context.Context, string) (string, error) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	return func(ctx context.Context, key string) (string, error) {
+		mu.Lock()
+		calls[key]++
+		n := calls[key]
+		mu.Unlock()
+
+		if key == "refused" {
+			return "", errRefused
+		}
+		if f := failures[key]; f < 0 || n <= f {
+			return "", errFlaky
+		}
+		return "v-" + key, nil
+	}
+}
+
+func TestFetchAllRetriesAndAggregatesErrors(t *testing.T) {
+	keys := []string{"broken", "ok", "refused", "flaky"}
+	fetch := failingFetch(map[string]int{"broken": -1, "flaky": 2})
+
+	res, err := FetchAll(context.Background(), keys, fetch, fastFetchOptions())
+
+	wantAttempts := map[string]int{"broken": 3, "ok": 1, "refused": 1, "flaky": 3}
+	for k, want := range wantAttempts {
+		if got := res.Attempts[k]; got != want {
+			t.Errorf("%s: %d attempts, want %d", k, got, want)
+		}
+	}
+	if res.Values["ok"] != "v-ok" || res.Values["flaky"] != "v-flaky" || len(res.Values) != 2 {
+		t.Errorf("values %v", res.Values)
+	}
+
+	var fetchErr *FetchError[string]
+	if !errors.As(err, &fetchErr) {
+		t.Fatalf("FetchAll = %v, want a *FetchError", err)
+	}
+	// Request order, though refused fails well before broken
+	if want := []string{"broken", "refused"}; !slices.Equal(fetchErr.Keys, want) || !slices.Equal(res.FailedKeys(), want) {
+		t.Errorf("failed keys %v, FailedKeys %v, want %v", fetchErr.Keys, res.FailedKeys(), want)
+	}
+	if fetchErr.Total != 4 || !strings.HasPrefix(err.Error(), "2 of 4 fetches failed; broken: ") {
+		t.Errorf("error %q, total %d", err, fetchErr.Total)
+	}
+
+	unwrapped := fetchErr.Unwrap()
+	if len(unwrapped) != 2 || !errors.Is(unwrapped[0], errFlaky) || unwrapped[1] != errRefused {
+		t.Errorf("Unwrap = %v, want broken's then refused's error", unwrapped)
+	}
+	if !errors.Is(err, errFlaky) || !errors.Is(err, errRefused) {
+		t.Errorf("errors.Is misses a per-key error in %v", err)
+	}
+	if !strings.Contains(res.Errors["broken"].Error(), "failed after 3 attempts") {
+		t.Errorf("broken: %v, want the attempt count", res.Errors["broken"])
+	}
+}
+
+func TestFetchAllCallTimeout(t *testing.T) {
+	opts := fastFetchOptions()
+	opts.CallTimeout = 20 * time.Millisecond
+
+	var slowCalls atomic.Int32
+	fetch := func(ctx context.Context, key string) (string, error) {
+		// hang hangs every time, slow only on its first attempt
+		if key == "hang" || (key == "slow" && slowCalls.Add(1) == 1) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return "v-" + key, nil
+	}
+
+	start := time.Now()
+	res, err := FetchAll(context.Background(), []string{"hang", "slow"}, fetch, opts)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v, want each attempt cut off at %v", elapsed, opts.CallTimeout)
+	}
+	if err == nil {
+		t.Fatal("FetchAll succeeded with a key that never answers")
+	}
+
+	if res.Values["slow"] != "v-slow" || res.Attempts["slow"] != 2 {
+		t.Errorf("slow: value %q after %d attempts, want retried once", res.Values["slow"], res.Attempts["slow"])
+	}
+	hangErr := res.Errors["hang"]
+	if res.Attempts["hang"] != 3 || !errors.Is(hangErr, errCallTimeout) || !errors.Is(hangErr, context.DeadlineExceeded) {
+		t.Errorf("hang: %v after %d attempts, want 3 call timeouts", hangErr, res.Attempts["hang"])
+	}
+}
+
+func TestFetchAllFillsInKeysAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := fastFetchOptions()
+	opts.MaxConcurrency = 1
+	keys := []string{"a", "b", "c", "d"}
+	fetch := func(ctx context.Context, key string) (string, error) {
+		if key == "b" {
+			cancel()
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return "v-" + key, nil
+	}
+
+	res, err := FetchAll(ctx, keys, fetch, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("FetchAll = %v, want context.Canceled", err)
+	}
+	if res.Values["a"] != "v-a" {
+		t.Errorf("a: %q, want fetched before the cancel", res.Values["a"])
+	}
+	for _, k := range []string{"b", "c", "d"} {
+		if !errors.Is(res.Errors[k], context.Canceled) {
+			t.Errorf("%s: %v, want context.Canceled", k, res.Errors[k])
+		}
+	}
+	if res.Attempts["c"] != 0 || res.Attempts["d"] != 0 {
+		t.Errorf("attempts %v, want none for unreached keys", res.Attempts)
+	}
+	if !slices.Equal(res.FailedKeys(), []string{"b", "c", "d"}) {
+		t.Errorf("FailedKeys = %v", res.FailedKeys())
+	}
+}
+
+func TestStreamAllLimitsConcurrency(t *testing.T) {
+	opts := fastFetchOptions()
+	opts.MaxConcurrency = 2
+
+	var inFlight, peak atomic.Int32
+	fetch := func(ctx context.Context, key int) (int, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(5 * time.Millisecond)
+		return key * 2, nil
+	}
+
+	keys := make([]int, 10)
+	for i := range keys {
+		keys[i] = i
+	}
+	seen := make(map[int]bool)
+	for out := range StreamAll(context.Background(), keys, fetch, opts) {
+		if out.Err != nil || out.Value != out.Key*2 {
+			t.Errorf("outcome %+v", out)
+		}
+		seen[out.Key] = true
+	}
+	if len(seen) != len(keys) {
+		t.Errorf("streamed %d outcomes, want %d", len(seen), len(keys))
+	}
+	if p := peak.Load(); p > 2 {
+		t.Errorf("%d calls in flight, want at most 2", p)
+	}
+}
+
+func TestStreamAllClosesOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := fastFetchOptions()
+	opts.MaxConcurrency = 1
+	fetch := func(ctx context.Context, key int) (int, error) { return key, nil }
+
+	keys := make([]int, 100)
+	out := StreamAll(ctx, keys, fetch, opts)
+	<-out
+	cancel() // stop reading: the stream must still close
+
+	timeout := time.After(time.Second)
+	n := 1
+	for open := true; open; {
+		select {
+		case _, ok := <-out:
+			if ok {
+				n++
+			}
+			open = ok
+		case <-timeout:
+			t.Fatal("stream not closed after cancel")
+		}
+	}
+	if n == len(keys) {
+		t.Errorf("all %d outcomes sent despite the cancel", n)
+	}
+}
+
+func TestCallWithRetryBacksOffAndWaitsOnLimiter(t *testing.T) {
+	opts := FetchOptions{Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: 20 * time.Millisecond, Multiplier: 2}}
+
+	var calls []time.Time
+	fetch := func(ctx context.Context, key string) (string, error) {
+		calls = append(calls, time.Now())
+		return "", fmt.Errorf("attempt %d: %w", len(calls), errFlaky)
+	}
+
+	_, attempts, err := callWithRetry(context.Background(), "k", fetch, opts, rate.NewLimiter(rate.Inf, 1))
+	if attempts != 3 || !errors.Is(err, errFlaky) {
+		t.Fatalf("callWithRetry = %d attempts, %v", attempts, err)
+	}
+	for i := 1; i < len(calls); i++ {
+		if gap, want := calls[i].Sub(calls[i-1]), opts.Retry.Backoff(i); gap < want {
+			t.Errorf("retry %d after %v, want at least %v", i, gap, want)
+		}
+	}
+
+	// Retries take tokens too: three attempts at 20/s with no burst to
+	// spare take two refills
+	opts.Retry.BaseDelay = 0
+	calls = nil
+	start := time.Now()
+	callWithRetry(context.Background(), "k", fetch, opts, rate.NewLimiter(20, 1))
+	if elapsed := time.Since(start); len(calls) != 3 || elapsed < 90*time.Millisecond {
+		t.Errorf("%d attempts in %v, want 3 paced by the limiter", len(calls), elapsed)
+	}
+
+	// A context that ends while waiting on the limiter stops before the
+	// next attempt
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	calls = nil
+	_, attempts, err = callWithRetry(ctx, "k", fetch, opts, rate.NewLimiter(1, 1))
+	if attempts != 1 || len(calls) != 1 || err == nil {
+		t.Errorf("callWithRetry = %d attempts (%d calls), %v, want to stop after 1", attempts, len(calls), err)
+	}
+}
@@ -4,10 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"sync"
 	"time"
-
-	"golang.org/x/time/rate"
 )
 
 // Mock API response
@@ -38,68 +35,55 @@ func fetchUserData(ctx context.Context, userID int) (*UserData, error) {
 	}, nil
 }
 
-func fetchUserDataWithRetry(ctx context.Context, userID int, maxRetries int) (*UserData, error) {
-
-	var lastError error
+// Simulates a batch endpoint: one round trip for many users. Results and
+// errors line up with userIDs.
+func fetchUserDataBatch(ctx context.Context, userIDs []int) ([]*UserData, []error) {
+	users := make([]*UserData, len(userIDs))
+	errs := make([]error, len(userIDs))
 
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if ctx.Err() != nil {
-			return nil, ctx.Err()
+	select {
+	case <-time.After(150 * time.Millisecond):
+	case <-ctx.Done():
+		for i := range errs {
+			errs[i] = ctx.Err()
 		}
+		return users, errs
+	}
 
-		data, err := fetchUserData(ctx, userID)
-		if err == nil {
-			return data, nil
+	for i, userID := range userIDs {
+		if userID%15 == 0 {
+			errs[i] = fmt.Errorf("API error for user %d", userID)
+			continue
 		}
-
-		lastError = err
-
-		if attempt < maxRetries-1 {
-			backoff := time.Duration(attempt*100) * time.Millisecond
-			select {
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			case <-time.After(backoff):
-			}
+		users[i] = &UserData{
+			ID:    userID,
+			Name:  fmt.Sprintf("User%d", userID),
+			Email: fmt.Sprintf("user%d@example.com", userID),
 		}
-
 	}
-
-	return nil, fmt.Errorf("failed after %d attempts: %w", maxRetries, lastError)
+	return users, errs
 }
 
-// TODO: Implement this function
-// It should fetch data for all userIDs concurrently while respecting the rate limit
-func fetchAllUsers(ctx context.Context, userIDs []int, maxRPS int) (map[int]*UserData, error) {
-
-	if len(userIDs) == 0 {
-		return make(map[int]*UserData), nil
-	}
-
-	results := make(map[int]*UserData)
-	var mu sync.Mutex
-	var wg sync.WaitGroup
+// UserResult is the outcome of fetching one user
+type UserResult = FetchOutcome[int, *UserData]
 
-	limiter := rate.NewLimiter(rate.Limit(maxRPS), maxRPS)
+// BatchFetchResult reports every requested ID: fetched, or why not
+type BatchFetchResult = FetchResult[int, *UserData]
 
-	for _, userID := range userIDs {
-		if err := limiter.Wait(ctx); err != nil {
-			break
-		}
+// streamUsers fetches userIDs concurrently and sends each result as it
+// completes
+func streamUsers(ctx context.Context, userIDs []int, opts FetchOptions) <-chan UserResult {
+	return StreamAll(ctx, userIDs, fetchUserData, opts)
+}
 
-		wg.Add(1)
-		go func(id int) {
-			defer wg.Done()
-			data, err := fetchUserDataWithRetry(ctx, id, 3)
-			if err == nil {
-				mu.Lock()
-				results[id] = data
-				mu.Unlock()
-			}
-		}(userID)
-	}
-	wg.Wait()
-	return results, ctx.Err()
+// TODO: Implement this function
+// It should fetch data for all userIDs concurrently while respecting the rate limit
+//
+// Every ID ends up in Values or Errors. The error is ctx.Err() if the
+// context ended before all IDs were fetched, otherwise a *FetchError
+// listing the IDs that failed.
+func fetchAllUsers(ctx context.Context, userIDs []int, opts FetchOptions) (*BatchFetchResult, error) {
+	return FetchAll(ctx, userIDs, fetchUserData, opts)
 }
 
 func main3() {
@@ -117,19 +101,37 @@ func main3() {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout*30)
 	defer cancel()
 
+	opts := DefaultFetchOptions(maxRPS)
+	opts.MaxConcurrency = 4
+	opts.CallTimeout = time.Second
+
 	start := time.Now()
-	results, err := fetchAllUsers(ctx, userIDs, 10)
+	results, err := fetchAllUsers(ctx, userIDs, opts)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 	}
 	elapsed := time.Since(start)
 
 	count := 0
-	for _, data := range results {
+	for _, data := range results.Values {
 		b, _ := json.Marshal(data)
 		fmt.Println(string(b))
 		count++
 	}
+	for _, id := range results.FailedKeys() {
+		fmt.Printf("User %d (%d attempts): %v\n", id, results.Attempts[id], results.Errors[id])
+	}
 
-	fmt.Printf("Successfully fetched %d/%d users in %v\n", len(results), len(userIDs), elapsed)
+	fmt.Printf("Successfully fetched %d/%d users in %v\n", len(results.Values), len(userIDs), elapsed)
+
+	// Streaming: handle each user as soon as it arrives
+	streamCtx, cancelStream := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelStream()
+	streamed := 0
+	for res := range streamUsers(streamCtx, userIDs[:20], opts) {
+		if res.Err == nil {
+			streamed++
+		}
+	}
+	fmt.Printf("Streamed %d/20 users\n", streamed)
 }
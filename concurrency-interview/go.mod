@@ -2,4 +2,4 @@ module interview
 
 go 1.25.1
 
-require golang.org/x/time v0.13.0 // indirect
+require golang.org/x/time v0.13.0
@@ -3,10 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
-	"sync"
 	"time"
-
-	"golang.org/x/time/rate"
 )
 
 // ServiceMetrics represents metrics from a single service
@@ -38,45 +35,40 @@ func fetchServiceMetrics(serviceID string) (*ServiceMetrics, error) {
 	}, nil
 }
 
+// fetchServiceMetricsCtx makes fetchServiceMetrics, which takes no
+// context, give up once ctx is done. The abandoned call finishes in the
+// background.
+func fetchServiceMetricsCtx(ctx context.Context, serviceID string) (*ServiceMetrics, error) {
+	type reply struct {
+		m   *ServiceMetrics
+		err error
+	}
+	done := make(chan reply, 1)
+	go func() {
+		m, err := fetchServiceMetrics(serviceID)
+		done <- reply{m, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.m, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // TODO: Implement this function
 // It should fetch metrics from all services concurrently while respecting the rate limit
+//
+// Services that fail are left out of the map and reported by a
+// *FetchError; the error is ctx.Err() if the context ended first.
 func aggregateMetrics(ctx context.Context, serviceIDs []string, maxRPS int) (map[string]*ServiceMetrics, error) {
-	// Your implementation here
-
-	result := make(map[string]*ServiceMetrics)
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-
-	limiter := rate.NewLimiter(rate.Limit(maxRPS), maxRPS)
-	semaphore := make(chan struct{}, maxRPS)
-
-	for _, serviceId := range serviceIDs {
-		if err := limiter.Wait(ctx); err != nil {
-			break
-		}
-
-		select {
-		case semaphore <- struct{}{}:
-
-		case <-ctx.Done():
-			goto done
-		}
-		wg.Add(1)
-		go func(id string) {
-			defer wg.Done()
-			defer func() { <-semaphore }()
-			data, err := fetchServiceMetrics(id)
-			if err == nil {
-				mu.Lock()
-				result[id] = data
-				mu.Unlock()
-			}
+	opts := DefaultFetchOptions(maxRPS)
+	opts.CallTimeout = time.Second
+	opts.Retry.MaxAttempts = 2
 
-		}(serviceId)
-	}
-done:
-	wg.Wait()
-	return result, ctx.Err()
+	result, err := FetchAll(ctx, serviceIDs, fetchServiceMetricsCtx, opts)
+	return result.Values, err
 }
 
 func MetricsAggregator() {
@@ -86,18 +78,24 @@ func MetricsAggregator() {
 		serviceIDs[i] = fmt.Sprintf("service-%d", i+1)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
-
-	start := time.Now()
-	results, err := aggregateMetrics(ctx, serviceIDs, 5)
-	elapsed := time.Since(start)
-
+	rules, err := ParseAlertRules([]byte(demoAlertRules))
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
 
-	fmt.Printf("Successfully fetched metrics from %d/%d services in %v\n",
-		len(results), len(serviceIDs), elapsed)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	for report := range PollFleet(ctx, serviceIDs, 5*time.Second, 25, rules) {
+		r := report.Rollup
+		fmt.Printf("[%s] %d/%d services up\n", r.At.Format(time.TimeOnly), len(r.Metrics), len(r.ServiceIDs))
+		fmt.Printf("  cpu:      %v\n  memory:   %v\n  requests: %v\n", r.CPU, r.Memory, r.RequestRate)
+		if len(r.Unavailable) > 0 {
+			fmt.Printf("  unavailable: %v\n", r.Unavailable)
+		}
+		for _, a := range report.Alerts {
+			fmt.Printf("  %v\n", a)
+		}
+	}
 }
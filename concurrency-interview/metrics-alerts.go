@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// AlertRule fires once its condition has held for For consecutive polls.
+// With Stat set it checks a fleet-wide figure, otherwise each service.
+type AlertRule struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"` // cpu, memory, request_rate or unavailable
+	Stat      string  `json:"stat"`   // mean, max or p95; count for unavailable
+	Op        string  `json:"op"`     // >, >=, < or <=
+	Threshold float64 `json:"threshold"`
+	For       int     `json:"for"` // consecutive polls; 0 means 1
+}
+
+func (r *AlertRule) validate() error {
+	switch r.Metric {
+	case "cpu", "memory", "request_rate":
+		if r.Stat != "" && r.Stat != "mean" && r.Stat != "max" && r.Stat != "p95" {
+			return fmt.Errorf("rule %q: stat %q, want mean, max or p95", r.Name, r.Stat)
+		}
+	case "unavailable":
+		if r.Stat != "" && r.Stat != "count" {
+			return fmt.Errorf("rule %q: stat %q, want count", r.Name, r.Stat)
+		}
+	default:
+		return fmt.Errorf("rule %q: unknown metric %q", r.Name, r.Metric)
+	}
+	switch r.Op {
+	case ">", ">=", "<", "<=":
+	default:
+		return fmt.Errorf("rule %q: unknown op %q", r.Name, r.Op)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("rule %q: negative for", r.Name)
+	}
+	return nil
+}
+
+func (r *AlertRule) holds(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	default:
+		return v <= r.Threshold
+	}
+}
+
+// fleetValue is the figure a fleet-wide rule checks, if any service
+// reported the metric
+func (r *AlertRule) fleetValue(roll *FleetRollup) (float64, bool) {
+	var m MetricRollup
+	switch r.Metric {
+	case "unavailable":
+		return float64(len(roll.Unavailable)), true
+	case "cpu":
+		m = roll.CPU
+	case "memory":
+		m = roll.Memory
+	case "request_rate":
+		m = roll.RequestRate
+	}
+	if m.Count == 0 {
+		return 0, false
+	}
+	switch r.Stat {
+	case "mean":
+		return m.Mean, true
+	case "max":
+		return m.Max, true
+	default:
+		return m.P95, true
+	}
+}
+
+// serviceValue is the figure a per-service rule checks, if the poll got
+// one
+func (r *AlertRule) serviceValue(roll *FleetRollup, id string) (float64, bool) {
+	if r.Metric == "unavailable" {
+		if _, down := roll.Errors[id]; down {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	m, ok := roll.Metrics[id]
+	if !ok {
+		return 0, false
+	}
+	switch r.Metric {
+	case "cpu":
+		return m.CPUUsage, true
+	case "memory":
+		return m.MemoryUsage, true
+	default:
+		return float64(m.RequestRate), true
+	}
+}
+
+// ParseAlertRules reads rules from JSON of the form {"rules": [...]}
+func ParseAlertRules(data []byte) ([]AlertRule, error) {
+	var cfg struct {
+		Rules []AlertRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse alert rules: %w", err)
+	}
+	for i := range cfg.Rules {
+		if err := cfg.Rules[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	return cfg.Rules, nil
+}
+
+func LoadAlertRules(path string) ([]AlertRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read alert rules: %w", err)
+	}
+	return ParseAlertRules(data)
+}
+
+// --------------------
+// Evaluation
+// --------------------
+
+// Alert reports a rule starting to fire, or, with Resolved, no longer
+// holding
+type Alert struct {
+	Rule     string
+	Service  string // empty for fleet-wide rules
+	Value    float64
+	Polls    int // consecutive polls the condition held
+	Since    time.Time
+	Resolved bool
+}
+
+func (a Alert) String() string {
+	subject := a.Service
+	if subject == "" {
+		subject = "fleet"
+	}
+	if a.Resolved {
+		return fmt.Sprintf("RESOLVED %s on %s after %d polls", a.Rule, subject, a.Polls)
+	}
+	return fmt.Sprintf("FIRING %s on %s: %.2f for %d polls since %s",
+		a.Rule, subject, a.Value, a.Polls, a.Since.Format(time.TimeOnly))
+}
+
+type alertKey struct {
+	rule    int
+	service string
+}
+
+type alertStreak struct {
+	polls  int
+	since  time.Time
+	firing bool
+}
+
+// AlertEvaluator tracks how long each rule has held, per service for
+// per-service rules. It is not safe for concurrent use.
+type AlertEvaluator struct {
+	rules   []AlertRule
+	streaks map[alertKey]*alertStreak
+}
+
+func NewAlertEvaluator(rules []AlertRule) *AlertEvaluator {
+	return &AlertEvaluator{rules: rules, streaks: make(map[alertKey]*alertStreak)}
+}
+
+// Evaluate folds in one poll and returns the alerts that started firing
+// or resolved with it. A service missing from the poll breaks its streak.
+// A fleet rule is skipped, streak and all, when no service reported its
+// metric: an empty rollup reads 0 and would trip every < rule.
+func (e *AlertEvaluator) Evaluate(roll *FleetRollup) []Alert {
+	var alerts []Alert
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if rule.Stat != "" {
+			v, ok := rule.fleetValue(roll)
+			if !ok {
+				continue
+			}
+			alerts = e.check(alerts, alertKey{rule: i}, rule, v, rule.holds(v), roll.At)
+			continue
+		}
+		for _, id := range roll.ServiceIDs {
+			v, ok := rule.serviceValue(roll, id)
+			alerts = e.check(alerts, alertKey{rule: i, service: id}, rule, v, ok && rule.holds(v), roll.At)
+		}
+	}
+	return alerts
+}
+
+func (e *AlertEvaluator) check(alerts []Alert, key alertKey, rule *AlertRule, v float64, holds bool, at time.Time) []Alert {
+	streak := e.streaks[key]
+	if !holds {
+		if streak != nil && streak.firing {
+			alerts = append(alerts, Alert{Rule: rule.Name, Service: key.service, Value: v, Polls: streak.polls, Since: streak.since, Resolved: true})
+		}
+		delete(e.streaks, key)
+		return alerts
+	}
+
+	if streak == nil {
+		streak = &alertStreak{since: at}
+		e.streaks[key] = streak
+	}
+	streak.polls++
+	if !streak.firing && streak.polls >= max(rule.For, 1) {
+		streak.firing = true
+		alerts = append(alerts, Alert{Rule: rule.Name, Service: key.service, Value: v, Polls: streak.polls, Since: streak.since})
+	}
+	return alerts
+}
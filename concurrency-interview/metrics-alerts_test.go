@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFleetRulesSkipPollsWithoutData(t *testing.T) {
+	ids := []string{"a", "b"}
+	healthy := func(at time.Time, cpu float64) *FleetRollup {
+		return newFleetRollup(at, ids, map[string]*ServiceMetrics{
+			"a": {ServiceID: "a", CPUUsage: cpu},
+			"b": {ServiceID: "b", CPUUsage: cpu},
+		}, nil)
+	}
+	allDown := func(at time.Time) *FleetRollup {
+		down := errors.New("unreachable")
+		return newFleetRollup(at, ids, map[string]*ServiceMetrics{}, &FetchError[string]{
+			Total:  len(ids),
+			Keys:   ids,
+			Errors: map[string]error{"a": down, "b": down},
+		})
+	}
+
+	eval := NewAlertEvaluator([]AlertRule{
+		{Name: "idle", Metric: "cpu", Stat: "mean", Op: "<", Threshold: 5},
+		{Name: "hot", Metric: "cpu", Stat: "max", Op: ">", Threshold: 90, For: 2},
+		{Name: "outage", Metric: "unavailable", Stat: "count", Op: ">=", Threshold: 2},
+	})
+	rules := func(alerts []Alert) map[string]bool {
+		out := make(map[string]bool)
+		for _, a := range alerts {
+			out[a.Rule] = !a.Resolved
+		}
+		return out
+	}
+
+	start := time.Now()
+	if got := rules(eval.Evaluate(healthy(start, 95))); len(got) != 0 {
+		t.Fatalf("first hot poll raised %v", got)
+	}
+
+	// No CPU figures at all: idle must not fire on the empty rollup's 0,
+	// and hot's streak must survive the gap
+	roll := allDown(start.Add(time.Second))
+	if roll.CPU.Count != 0 {
+		t.Fatalf("CPU rollup %+v, want no data", roll.CPU)
+	}
+	if got := rules(eval.Evaluate(roll)); len(got) != 1 || !got["outage"] {
+		t.Fatalf("all-down poll raised %v, want just outage", got)
+	}
+
+	got := rules(eval.Evaluate(healthy(start.Add(2*time.Second), 95)))
+	if !got["hot"] || got["idle"] {
+		t.Errorf("second hot poll raised %v, want hot firing", got)
+	}
+	if firing, ok := got["outage"]; !ok || firing {
+		t.Errorf("outage not resolved: %v", got)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"interview/pipeline"
+)
+
+// MetricRollup summarizes one metric across the fleet
+type MetricRollup struct {
+	Count int // services that reported; the figures are 0 without any
+	Mean  float64
+	Max   float64
+	P95   float64
+}
+
+func newMetricRollup(s *pipeline.Stats) MetricRollup {
+	if s.Count == 0 {
+		return MetricRollup{}
+	}
+	return MetricRollup{Count: s.Count, Mean: s.Mean(), Max: s.Max, P95: s.Percentile(0.95)}
+}
+
+func (r MetricRollup) String() string {
+	if r.Count == 0 {
+		return "no data"
+	}
+	return fmt.Sprintf("mean %.2f, max %.2f, p95 %.2f", r.Mean, r.Max, r.P95)
+}
+
+// FleetRollup is the outcome of one poll of every service
+type FleetRollup struct {
+	At          time.Time
+	ServiceIDs  []string // polled, in order
+	CPU         MetricRollup
+	Memory      MetricRollup
+	RequestRate MetricRollup
+	Unavailable []string // in poll order
+	Errors      map[string]error
+	Metrics     map[string]*ServiceMetrics
+}
+
+func newFleetRollup(at time.Time, serviceIDs []string, metrics map[string]*ServiceMetrics, failed *FetchError[string]) *FleetRollup {
+	r := &FleetRollup{
+		At:         at,
+		ServiceIDs: serviceIDs,
+		Errors:     make(map[string]error),
+		Metrics:    metrics,
+	}
+	if failed != nil {
+		r.Unavailable = failed.Keys
+		r.Errors = failed.Errors
+	}
+
+	var cpu, mem, reqs pipeline.Stats
+	for _, m := range metrics {
+		cpu.Add(m.CPUUsage)
+		mem.Add(m.MemoryUsage)
+		reqs.Add(float64(m.RequestRate))
+	}
+	r.CPU = newMetricRollup(&cpu)
+	r.Memory = newMetricRollup(&mem)
+	r.RequestRate = newMetricRollup(&reqs)
+	return r
+}
+
+// pollFleet fetches every service once and rolls the results up. Failed
+// services are listed as unavailable; the error is only for ctx ending.
+func pollFleet(ctx context.Context, serviceIDs []string, maxRPS int) (*FleetRollup, error) {
+	metrics, err := aggregateMetrics(ctx, serviceIDs, maxRPS)
+
+	var fetchErr *FetchError[string]
+	if err != nil && !errors.As(err, &fetchErr) {
+		return nil, err
+	}
+	return newFleetRollup(time.Now(), serviceIDs, metrics, fetchErr), nil
+}
+
+// FleetReport is one poll's rollup and the alerts it raised or resolved
+type FleetReport struct {
+	Rollup *FleetRollup
+	Alerts []Alert
+}
+
+// PollFleet polls every service each interval, starting at once, and
+// sends a report per poll. A poll that overruns the interval delays the
+// next rather than overlapping it. The channel closes once ctx is done.
+func PollFleet(ctx context.Context, serviceIDs []string, interval time.Duration, maxRPS int, rules []AlertRule) <-chan FleetReport {
+	out := make(chan FleetReport)
+	alerts := NewAlertEvaluator(rules)
+
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			rollup, err := pollFleet(ctx, serviceIDs, maxRPS)
+			if err != nil {
+				return
+			}
+			report := FleetReport{Rollup: rollup, Alerts: alerts.Evaluate(rollup)}
+
+			select {
+			case out <- report:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// demoAlertRules is what an alert rules file might hold
+const demoAlertRules = `{
+	"rules": [
+		{"name": "fleet-cpu-hot", "metric": "cpu", "stat": "p95", "op": ">", "threshold": 0.9, "for": 2},
+		{"name": "service-cpu-hot", "metric": "cpu", "op": ">", "threshold": 0.9, "for": 3},
+		{"name": "service-down", "metric": "unavailable", "op": ">=", "threshold": 1, "for": 2},
+		{"name": "fleet-degraded", "metric": "unavailable", "stat": "count", "op": ">", "threshold": 10, "for": 1}
+	]
+}`
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DeliveryState is where a notification is in its lifecycle
+type DeliveryState string
+
+const (
+	StateQueued DeliveryState = "queued"
+	StateSent   DeliveryState = "sent"
+	StateFailed DeliveryState = "failed" // attempt failed, will retry
+	StateDead   DeliveryState = "dead"   // gave up
+)
+
+// ErrDuplicate is returned by Submit for an idempotency key already seen
+// within the dedup window
+var ErrDuplicate = errors.New("duplicate notification")
+
+// DeliveryEvent is one entry in a notification's history
+type DeliveryEvent struct {
+	State   DeliveryState `json:"state"`
+	Attempt int           `json:"attempt"`
+	Time    time.Time     `json:"time"`
+	Detail  string        `json:"detail,omitempty"`
+}
+
+// DeliveryStatus is the full delivery history of one notification
+type DeliveryStatus struct {
+	ID             string          `json:"id"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	Channel        Channel         `json:"channel"`
+	State          DeliveryState   `json:"state"`
+	History        []DeliveryEvent `json:"history"`
+
+	sending bool // a worker currently holds this notification
+	updated time.Time
+}
+
+func (s *DeliveryStatus) terminal() bool {
+	return s.State == StateSent || s.State == StateDead
+}
+
+// DeliveryTracker deduplicates submissions by idempotency key and records
+// per-attempt delivery status
+type DeliveryTracker struct {
+	mu        sync.Mutex
+	window    time.Duration
+	keys      map[string]string // idempotency key -> notification ID
+	statuses  map[string]*DeliveryStatus
+	members   map[string][]*Notification // digest ID -> notifications it carries
+	lastPrune time.Time
+}
+
+func NewDeliveryTracker(window time.Duration) *DeliveryTracker {
+	return &DeliveryTracker{
+		window:    window,
+		keys:      make(map[string]string),
+		statuses:  make(map[string]*DeliveryStatus),
+		members:   make(map[string][]*Notification),
+		lastPrune: time.Now(),
+	}
+}
+
+// SetWindow changes how long idempotency keys are remembered
+func (t *DeliveryTracker) SetWindow(window time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.window = window
+}
+
+func idempotencyKey(notif *Notification) string {
+	if notif.IdempotencyKey != "" {
+		return notif.IdempotencyKey
+	}
+	return notif.ID
+}
+
+// Accept registers a new submission, or returns ErrDuplicate if its key
+// was seen within the dedup window
+func (t *DeliveryTracker) Accept(notif *Notification) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.pruneLocked(now)
+
+	key := idempotencyKey(notif)
+	if _, seen := t.keys[key]; seen {
+		return ErrDuplicate
+	}
+	if _, seen := t.statuses[notif.ID]; seen {
+		return ErrDuplicate
+	}
+
+	t.keys[key] = notif.ID
+	t.statuses[notif.ID] = &DeliveryStatus{
+		ID:             notif.ID,
+		IdempotencyKey: key,
+		Channel:        notif.Type,
+		State:          StateQueued,
+		History:        []DeliveryEvent{{State: StateQueued, Time: now}},
+		updated:        now,
+	}
+	return nil
+}
+
+// Forget drops a submission that never made it onto the queue, so the
+// caller may submit it again
+func (t *DeliveryTracker) Forget(notif *Notification) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.keys, idempotencyKey(notif))
+	delete(t.statuses, notif.ID)
+}
+
+// Begin claims notif for sending. It returns false if it was already sent
+// or given up on, or another worker is sending it right now.
+func (t *DeliveryTracker) Begin(notif *Notification) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.statuses[notif.ID]
+	if !ok {
+		// Restored from a retry store by an earlier process
+		st = &DeliveryStatus{ID: notif.ID, IdempotencyKey: idempotencyKey(notif), Channel: notif.Type}
+		t.statuses[notif.ID] = st
+		t.keys[st.IdempotencyKey] = notif.ID
+	}
+	if st.terminal() || st.sending {
+		return false
+	}
+	st.sending = true
+	return true
+}
+
+// Record appends an event and releases the worker's claim. For a digest
+// the event is passed on to every notification it carries, which settle
+// once it does.
+func (t *DeliveryTracker) Record(notif *Notification, state DeliveryState, detail string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.recordLocked(notif.ID, notif.Attempts, state, detail)
+
+	if items, ok := t.members[notif.ID]; ok {
+		itemDetail := "digest " + notif.ID
+		if detail != "" {
+			itemDetail += ": " + detail
+		}
+		for _, item := range items {
+			t.recordLocked(item.ID, notif.Attempts, state, itemDetail)
+		}
+		if state == StateSent || state == StateDead {
+			delete(t.members, notif.ID)
+		}
+	}
+}
+
+func (t *DeliveryTracker) recordLocked(id string, attempt int, state DeliveryState, detail string) {
+	st, ok := t.statuses[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	st.State = state
+	st.sending = false
+	st.updated = now
+	st.History = append(st.History, DeliveryEvent{
+		State:   state,
+		Attempt: attempt,
+		Time:    now,
+		Detail:  detail,
+	})
+}
+
+// Attach makes items follow digest's delivery outcome
+func (t *DeliveryTracker) Attach(digest *Notification, items []*Notification) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.members[digest.ID] = items
+}
+
+// GetStatus returns a copy of the delivery history for id
+func (t *DeliveryTracker) GetStatus(id string) (*DeliveryStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.statuses[id]
+	if !ok {
+		return nil, false
+	}
+	cp := *st
+	cp.History = append([]DeliveryEvent(nil), st.History...)
+	return &cp, true
+}
+
+// pruneLocked forgets finished notifications older than the dedup window.
+// It runs at most once per half window.
+func (t *DeliveryTracker) pruneLocked(now time.Time) {
+	if now.Sub(t.lastPrune) < t.window/2 {
+		return
+	}
+	t.lastPrune = now
+
+	for id, st := range t.statuses {
+		if st.terminal() && now.Sub(st.updated) > t.window {
+			delete(t.keys, st.IdempotencyKey)
+			delete(t.statuses, id)
+		}
+	}
+}
+
+// --------------------
+// Dispatcher status API
+// --------------------
+
+// SetDedupWindow changes how long idempotency keys are remembered
+// (default 10 minutes)
+func (d *Dispatcher) SetDedupWindow(window time.Duration) {
+	d.tracker.SetWindow(window)
+}
+
+// GetStatus returns the delivery history of a notification
+func (d *Dispatcher) GetStatus(id string) (*DeliveryStatus, bool) {
+	return d.tracker.GetStatus(id)
+}
+
+// StatusHandler serves GET /notifications/{id}/status
+func (d *Dispatcher) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /notifications/{id}/status", func(w http.ResponseWriter, r *http.Request) {
+		st, ok := d.GetStatus(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "notification not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	})
+	return mux
+}
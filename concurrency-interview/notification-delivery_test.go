@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeliveryTrackerDedupWindow(t *testing.T) {
+	const window = 50 * time.Millisecond
+	tr := NewDeliveryTracker(window)
+
+	sent := &Notification{ID: "n1", IdempotencyKey: "order-1"}
+	if err := tr.Accept(sent); err != nil {
+		t.Fatal(err)
+	}
+	tr.Record(sent, StateSent, "")
+	pending := &Notification{ID: "n2", IdempotencyKey: "order-2"}
+	if err := tr.Accept(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		notif *Notification
+	}{
+		{name: "same key", notif: &Notification{ID: "n3", IdempotencyKey: "order-1"}},
+		{name: "same ID", notif: &Notification{ID: "n1", IdempotencyKey: "order-9"}},
+		{name: "ID as default key", notif: &Notification{ID: "order-2"}},
+	}
+	for _, tt := range tests {
+		if err := tr.Accept(tt.notif); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("%s: Accept = %v, want ErrDuplicate", tt.name, err)
+		}
+	}
+
+	// Past the window the sent one is pruned, but the one still in
+	// flight keeps its key
+	time.Sleep(2 * window)
+	if err := tr.Accept(&Notification{ID: "n4", IdempotencyKey: "order-1"}); err != nil {
+		t.Errorf("Accept after the window = %v", err)
+	}
+	if _, ok := tr.GetStatus("n1"); ok {
+		t.Error("n1 still tracked after the window")
+	}
+	if err := tr.Accept(&Notification{ID: "n5", IdempotencyKey: "order-2"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Accept of an unfinished key = %v, want ErrDuplicate", err)
+	}
+	if _, ok := tr.GetStatus("n2"); !ok {
+		t.Error("unfinished n2 was pruned")
+	}
+
+	// Forget releases the key at once
+	tr.Forget(pending)
+	if err := tr.Accept(&Notification{ID: "n6", IdempotencyKey: "order-2"}); err != nil {
+		t.Errorf("Accept after Forget = %v", err)
+	}
+}
+
+func TestDeliveryTrackerBeginGuard(t *testing.T) {
+	tr := NewDeliveryTracker(time.Minute)
+	notif := &Notification{ID: "n1"}
+	if err := tr.Accept(notif); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		record DeliveryState // recorded before Begin, "" for none
+		want   bool
+	}{
+		{want: true},
+		{want: false}, // another worker holds it
+		{record: StateFailed, want: true},
+		{record: StateQueued, want: true},
+		{record: StateSent, want: false},
+		{want: false},
+	}
+	for i, s := range steps {
+		if s.record != "" {
+			tr.Record(notif, s.record, "")
+		}
+		if got := tr.Begin(notif); got != s.want {
+			t.Fatalf("step %d (after %q): Begin = %v, want %v", i, s.record, got, s.want)
+		}
+	}
+
+	dead := &Notification{ID: "n2"}
+	tr.Accept(dead)
+	tr.Begin(dead)
+	tr.Record(dead, StateDead, "gave up")
+	if tr.Begin(dead) {
+		t.Error("Begin claimed a dead notification")
+	}
+
+	// One restored from the retry store is tracked from its first claim
+	restored := &Notification{ID: "n3", IdempotencyKey: "order-3"}
+	if !tr.Begin(restored) {
+		t.Fatal("Begin refused an untracked notification")
+	}
+	if err := tr.Accept(&Notification{ID: "n4", IdempotencyKey: "order-3"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Accept with a restored key = %v, want ErrDuplicate", err)
+	}
+}
+
+func TestDeliveryStatusHandler(t *testing.T) {
+	d := NewDispatcher(1)
+	defer d.Shutdown(time.Second)
+	d.RegisterSender(ChannelEmail, senderFunc(func(context.Context, *Notification) error { return nil }))
+
+	notif := &Notification{ID: "n1", IdempotencyKey: "order-1", Type: ChannelEmail,
+		Recipient: Recipient{UserID: "u1", Email: "u1@example.com"}, Message: "hi"}
+	if err := d.Submit(context.Background(), notif); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, d, "n1", StateSent)
+
+	srv := httptest.NewServer(d.StatusHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/notifications/n1/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var st DeliveryStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.ID != "n1" || st.IdempotencyKey != "order-1" || st.State != StateSent {
+		t.Errorf("status %+v", st)
+	}
+	if n := len(st.History); n < 2 || st.History[0].State != StateQueued || st.History[n-1].State != StateSent {
+		t.Errorf("history %+v, want queued through sent", st.History)
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/notifications/missing/status", http.StatusNotFound},
+		{http.MethodPost, "/notifications/n1/status", http.StatusMethodNotAllowed},
+	} {
+		req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
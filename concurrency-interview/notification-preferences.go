@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priority controls how preferences treat a notification: high priority
+// ignores quiet hours, low priority may be batched into a digest
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// ErrNoChannel is returned by Submit when the recipient has opted out of
+// every channel they can be reached on
+var ErrNoChannel = errors.New("recipient has no enabled channel")
+
+// DefaultChannelOrder is the fallback order when a user hasn't set one
+var DefaultChannelOrder = []Channel{ChannelPush, ChannelSMS, ChannelEmail}
+
+// QuietHours is a daily window, in the user's timezone, during which
+// non-urgent notifications are held. Start after End wraps midnight.
+type QuietHours struct {
+	Start    string // "22:00"
+	End      string // "07:00"
+	Timezone string // IANA name, e.g. "Europe/Berlin"
+
+	loc        *time.Location
+	start, end int // minutes after midnight
+}
+
+// UserPreferences are one user's delivery settings
+type UserPreferences struct {
+	UserID       string
+	OptOut       map[Channel]bool
+	ChannelOrder []Channel     // fallback order; DefaultChannelOrder if empty
+	QuietHours   *QuietHours   // nil for none
+	DigestWindow time.Duration // batch low-priority notifications; 0 disables
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (q *QuietHours) compile() error {
+	var err error
+	if q.loc, err = time.LoadLocation(q.Timezone); err != nil {
+		return fmt.Errorf("quiet hours: %w", err)
+	}
+	if q.start, err = parseClock(q.Start); err != nil {
+		return fmt.Errorf("quiet hours: %w", err)
+	}
+	if q.end, err = parseClock(q.End); err != nil {
+		return fmt.Errorf("quiet hours: %w", err)
+	}
+	return nil
+}
+
+// until reports whether now falls in quiet hours and, if so, when they end
+func (q *QuietHours) until(now time.Time) (time.Time, bool) {
+	local := now.In(q.loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	// Built field by field rather than added to midnight, so the end
+	// lands on the wall clock time even across a DST change
+	end := func(days int) time.Time {
+		return time.Date(local.Year(), local.Month(), local.Day()+days, q.end/60, q.end%60, 0, 0, q.loc)
+	}
+
+	switch {
+	case q.start == q.end:
+		return time.Time{}, false
+	case q.start < q.end: // same-day window, e.g. 13:00-14:00
+		if minute >= q.start && minute < q.end {
+			return end(0), true
+		}
+	default: // wraps midnight, e.g. 22:00-07:00
+		if minute >= q.start {
+			return end(1), true
+		}
+		if minute < q.end {
+			return end(0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// reachable reports whether r has an address for ch
+func (r Recipient) reachable(ch Channel) bool {
+	switch ch {
+	case ChannelEmail:
+		return r.Email != ""
+	case ChannelSMS:
+		return r.Phone != ""
+	case ChannelPush:
+		return r.DeviceToken != ""
+	}
+	return false
+}
+
+// --------------------
+// PreferenceStore
+// --------------------
+
+type PreferenceStore struct {
+	mu    sync.RWMutex
+	prefs map[string]*UserPreferences
+}
+
+func NewPreferenceStore() *PreferenceStore {
+	return &PreferenceStore{prefs: make(map[string]*UserPreferences)}
+}
+
+func (s *PreferenceStore) Set(p UserPreferences) error {
+	if p.QuietHours != nil {
+		qh := *p.QuietHours
+		if err := qh.compile(); err != nil {
+			return err
+		}
+		p.QuietHours = &qh
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefs[p.UserID] = &p
+	return nil
+}
+
+// Get returns the user's preferences, or defaults if none are stored
+func (s *PreferenceStore) Get(userID string) *UserPreferences {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if p, ok := s.prefs[userID]; ok {
+		return p
+	}
+	return &UserPreferences{UserID: userID}
+}
+
+// chooseChannel keeps the requested channel if allowed, otherwise walks
+// the user's fallback order for one that is enabled and reachable. A
+// recipient with no addresses at all is trusted on the requested channel.
+func (p *UserPreferences) chooseChannel(requested Channel, r Recipient) (Channel, error) {
+	unaddressed := r.Email == "" && r.Phone == "" && r.DeviceToken == ""
+	if requested != "" && !p.OptOut[requested] && (unaddressed || r.reachable(requested)) {
+		return requested, nil
+	}
+
+	order := p.ChannelOrder
+	if len(order) == 0 {
+		order = DefaultChannelOrder
+	}
+	for _, ch := range order {
+		if !p.OptOut[ch] && r.reachable(ch) {
+			return ch, nil
+		}
+	}
+	return "", ErrNoChannel
+}
+
+// --------------------
+// Digest batching
+// --------------------
+
+type digestBucket struct {
+	userID    string
+	channel   Channel
+	recipient Recipient
+	items     []*Notification
+	due       time.Time
+}
+
+// Digester collects low-priority notifications per user and channel and
+// releases each bucket once its window has elapsed
+type Digester struct {
+	mu      sync.Mutex
+	buckets map[string]*digestBucket
+}
+
+func NewDigester() *Digester {
+	return &Digester{buckets: make(map[string]*digestBucket)}
+}
+
+func (g *Digester) Add(notif *Notification, window time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := notif.Recipient.UserID + "/" + string(notif.Type)
+	b, ok := g.buckets[key]
+	if !ok {
+		b = &digestBucket{
+			userID:    notif.Recipient.UserID,
+			channel:   notif.Type,
+			recipient: notif.Recipient,
+			due:       time.Now().Add(window),
+		}
+		g.buckets[key] = b
+	}
+	b.items = append(b.items, notif)
+}
+
+// due removes and returns buckets whose window has elapsed
+func (g *Digester) due(now time.Time) []*digestBucket {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var out []*digestBucket
+	for key, b := range g.buckets {
+		if !now.Before(b.due) {
+			out = append(out, b)
+			delete(g.buckets, key)
+		}
+	}
+	return out
+}
+
+func (g *Digester) Pending() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	n := 0
+	for _, b := range g.buckets {
+		n += len(b.items)
+	}
+	return n
+}
+
+// --------------------
+// Dispatcher integration
+// --------------------
+
+// SetPreferences stores a user's delivery preferences
+func (d *Dispatcher) SetPreferences(p UserPreferences) error {
+	return d.prefs.Set(p)
+}
+
+// route applies preferences to a submitted notification: it picks the
+// channel, then decides whether to send now, batch into a digest or hold
+// until quiet hours end. wait says whether to block for queue space.
+func (d *Dispatcher) route(ctx context.Context, notif *Notification, wait bool) error {
+	prefs := d.prefs.Get(notif.Recipient.UserID)
+
+	ch, err := prefs.chooseChannel(notif.Type, notif.Recipient)
+	if err != nil {
+		return err
+	}
+	notif.Type = ch
+
+	if err := d.tracker.Accept(notif); err != nil {
+		return err
+	}
+
+	if notif.Priority == PriorityLow && prefs.DigestWindow > 0 {
+		d.digests.Add(notif, prefs.DigestWindow)
+		d.tracker.Record(notif, StateQueued, "batched into digest")
+		return nil
+	}
+
+	if notif.Priority != PriorityHigh && prefs.QuietHours != nil {
+		if until, quiet := prefs.QuietHours.until(time.Now()); quiet {
+			d.retries.Schedule(notif, until)
+			d.tracker.Record(notif, StateQueued, "held for quiet hours until "+until.Format(time.RFC3339))
+			return nil
+		}
+	}
+
+	if err := d.enqueue(ctx, notif, wait); err != nil {
+		d.tracker.Forget(notif)
+		d.rejected.Add(1)
+		return err
+	}
+	d.submitted.Add(1)
+	return nil
+}
+
+// enqueue hands notif to the workers, waiting for space until ctx is
+// done if wait is set
+func (d *Dispatcher) enqueue(ctx context.Context, notif *Notification, wait bool) error {
+	select {
+	case <-d.ctx.Done():
+		return ErrClosed
+	case d.jobs <- notif:
+		return nil
+	default:
+		if !wait {
+			return ErrQueueFull
+		}
+	}
+
+	select {
+	case d.jobs <- notif:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.ctx.Done():
+		return ErrClosed
+	}
+}
+
+// runDigests flushes digest buckets as their windows close
+func (d *Dispatcher) runDigests() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			if n := d.digests.Pending(); n > 0 {
+				log.Printf("Digest handler stopping with %d unsent notifications", n)
+			}
+			return
+		case now := <-ticker.C:
+			for _, b := range d.digests.due(now) {
+				d.flushDigest(b)
+			}
+		}
+	}
+}
+
+// flushDigest renders each batched notification and submits one combined
+// message. The digest itself goes through quiet hours like any other, and
+// the notifications in it are sent or dead only once it is.
+func (d *Dispatcher) flushDigest(b *digestBucket) {
+	digest := &Notification{
+		ID:        fmt.Sprintf("digest-%s-%s-%d", b.userID, b.channel, time.Now().UnixNano()),
+		Type:      b.channel,
+		Recipient: b.recipient,
+		Priority:  PriorityNormal,
+	}
+
+	var lines []string
+	var included []*Notification
+	for _, item := range b.items {
+		msg, err := d.render(item)
+		if err != nil {
+			d.tracker.Record(item, StateDead, err.Error())
+			continue
+		}
+		lines = append(lines, "- "+msg.Text)
+		included = append(included, item)
+		d.tracker.Record(item, StateQueued, "included in "+digest.ID)
+	}
+	if len(lines) == 0 {
+		return
+	}
+	digest.Message = fmt.Sprintf("You have %d updates:\n%s", len(lines), strings.Join(lines, "\n"))
+
+	d.tracker.Attach(digest, included)
+	if err := d.route(d.ctx, digest, true); err != nil {
+		log.Printf("Digest %s not sent: %v", digest.ID, err)
+		d.tracker.Record(digest, StateDead, "not sent: "+err.Error())
+	}
+}
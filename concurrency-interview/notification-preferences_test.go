@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type senderFunc func(ctx context.Context, notif *Notification) error
+
+func (f senderFunc) Send(ctx context.Context, notif *Notification) error {
+	return f(ctx, notif)
+}
+
+// waitForState polls until id reaches want or the deadline passes, and
+// returns the last status seen
+func waitForState(t *testing.T, d *Dispatcher, id string, want DeliveryState) *DeliveryStatus {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		st, ok := d.GetStatus(id)
+		if !ok {
+			t.Fatalf("no status for %s", id)
+		}
+		if st.State == want || time.Now().After(deadline) {
+			return st
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDigestItemsFollowDigestOutcome(t *testing.T) {
+	tests := []struct {
+		name   string
+		send   error
+		optOut bool // recipient opts out before the flush, so routing fails
+		want   DeliveryState
+	}{
+		{name: "sent", want: StateSent},
+		{name: "permanent failure", send: permanent("test", errors.New("bad address")), want: StateDead},
+		{name: "route fails", optOut: true, want: StateDead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDispatcher(1)
+			defer d.Shutdown(time.Second)
+			d.RegisterSender(ChannelEmail, senderFunc(func(context.Context, *Notification) error { return tt.send }))
+
+			recipient := Recipient{UserID: "u1", Email: "u1@example.com"}
+			if err := d.SetPreferences(UserPreferences{UserID: "u1", DigestWindow: time.Hour}); err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{"n1", "n2"}
+			for _, id := range ids {
+				notif := &Notification{ID: id, Type: ChannelEmail, Priority: PriorityLow, Recipient: recipient, Message: "update " + id}
+				if err := d.Submit(context.Background(), notif); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.optOut {
+				d.SetPreferences(UserPreferences{UserID: "u1", OptOut: map[Channel]bool{ChannelEmail: true}})
+			}
+
+			for _, b := range d.digests.due(time.Now().Add(2 * time.Hour)) {
+				d.flushDigest(b)
+			}
+			for _, id := range ids {
+				if st := waitForState(t, d, id, tt.want); st.State != tt.want {
+					t.Errorf("%s: state %s, want %s; history %+v", id, st.State, tt.want, st.History)
+				}
+			}
+		})
+	}
+}
+
+func TestQuietHoursUntilAcrossDST(t *testing.T) {
+	q := &QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/Berlin"}
+	if err := q.compile(); err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+
+	// Clocks go forward at 02:00 on 2026-03-29 and back at 03:00 on
+	// 2026-10-25; quiet hours still end at 07:00 local
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 3, 28, 23, 0, 0, 0, q.loc), time.Date(2026, 3, 29, 7, 0, 0, 0, q.loc)},
+		{time.Date(2026, 3, 29, 1, 30, 0, 0, q.loc), time.Date(2026, 3, 29, 7, 0, 0, 0, q.loc)},
+		{time.Date(2026, 10, 24, 22, 30, 0, 0, q.loc), time.Date(2026, 10, 25, 7, 0, 0, 0, q.loc)},
+	}
+	for _, tt := range tests {
+		got, quiet := q.until(tt.now)
+		if !quiet || !got.Equal(tt.want) {
+			t.Errorf("until(%v) = %v, %v; want %v, true", tt.now, got, quiet, tt.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxRateWait is the longest a worker will sit on a token. Longer waits go
+// back through the delay queue so the worker can serve other channels.
+const maxRateWait = 2 * time.Second
+
+// RateLimiter is a token bucket holding up to burst tokens and refilling
+// continuously at ratePerSec, fractions included
+type RateLimiter struct {
+	lim *rate.Limiter
+}
+
+func NewRateLimiter(burst int, ratePerSec float64) *RateLimiter {
+	return &RateLimiter{lim: rate.NewLimiter(rate.Limit(ratePerSec), burst)}
+}
+
+// Allow takes a token if one is available now
+func (r *RateLimiter) Allow() bool {
+	return r.lim.Allow()
+}
+
+// Wait blocks until a token is available or ctx is done
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	return r.lim.Wait(ctx)
+}
+
+// ReserveAt takes a token at t that becomes usable after res.DelayFrom(t).
+// Call res.CancelAt(t) to give it back if the caller won't wait that long;
+// a later cancel can't return a token that was ready at once.
+func (r *RateLimiter) ReserveAt(t time.Time) *rate.Reservation {
+	return r.lim.ReserveN(t, 1)
+}
+
+// --------------------
+// KeyedLimiter
+// --------------------
+
+type keyedEntry struct {
+	key     string
+	limiter *RateLimiter
+	used    time.Time
+}
+
+// KeyedLimiter keeps one RateLimiter per key (recipient, provider, ...).
+// Keys are evicted least recently used first once there are more than
+// capacity, and any key idle longer than idleTTL is dropped on access.
+type KeyedLimiter struct {
+	mu       sync.Mutex
+	burst    int
+	rate     float64
+	capacity int
+	idleTTL  time.Duration
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+}
+
+func NewKeyedLimiter(burst int, ratePerSec float64, capacity int, idleTTL time.Duration) *KeyedLimiter {
+	return &KeyedLimiter{
+		burst:    burst,
+		rate:     ratePerSec,
+		capacity: capacity,
+		idleTTL:  idleTTL,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the limiter for key, creating it with a full bucket if new
+func (k *KeyedLimiter) Get(key string) *RateLimiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	k.evictIdleLocked(now)
+
+	if el, ok := k.entries[key]; ok {
+		e := el.Value.(*keyedEntry)
+		e.used = now
+		k.order.MoveToFront(el)
+		return e.limiter
+	}
+
+	e := &keyedEntry{key: key, limiter: NewRateLimiter(k.burst, k.rate), used: now}
+	k.entries[key] = k.order.PushFront(e)
+	for k.order.Len() > k.capacity {
+		k.removeLocked(k.order.Back())
+	}
+	return e.limiter
+}
+
+func (k *KeyedLimiter) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.order.Len()
+}
+
+// evictIdleLocked drops keys unused for idleTTL. An idle bucket has
+// refilled anyway, so forgetting it loses nothing.
+func (k *KeyedLimiter) evictIdleLocked(now time.Time) {
+	for el := k.order.Back(); el != nil; el = k.order.Back() {
+		if now.Sub(el.Value.(*keyedEntry).used) < k.idleTTL {
+			return
+		}
+		k.removeLocked(el)
+	}
+}
+
+func (k *KeyedLimiter) removeLocked(el *list.Element) {
+	k.order.Remove(el)
+	delete(k.entries, el.Value.(*keyedEntry).key)
+}
+
+// --------------------
+// Dispatcher integration
+// --------------------
+
+// waitForTokens reserves a token from the channel limiter and, for
+// addressed recipients, the recipient's own limiter. If both are ready
+// within maxRateWait the worker sleeps for them and returns 0; otherwise
+// the reservations are returned and it reports how long to defer instead.
+func (d *Dispatcher) waitForTokens(notif *Notification) (time.Duration, error) {
+	now := time.Now()
+	var reservations []*rate.Reservation
+	if limiter := d.limiters[notif.Type]; limiter != nil {
+		reservations = append(reservations, limiter.ReserveAt(now))
+	}
+	if notif.Recipient.UserID != "" {
+		reservations = append(reservations, d.recipientLimits.Get(notif.Recipient.UserID).ReserveAt(now))
+	}
+
+	cancelAll := func() {
+		for _, res := range reservations {
+			res.CancelAt(now)
+		}
+	}
+
+	var delay time.Duration
+	for _, res := range reservations {
+		if !res.OK() {
+			cancelAll()
+			return maxRateWait, nil
+		}
+		delay = max(delay, res.DelayFrom(now))
+	}
+	if delay == 0 {
+		return 0, nil
+	}
+	if delay > maxRateWait {
+		cancelAll()
+		return delay, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return 0, nil
+	case <-d.ctx.Done():
+		cancelAll()
+		return 0, d.ctx.Err()
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	k := NewKeyedLimiter(1, 1, 2, time.Hour)
+
+	a := k.Get("a")
+	b := k.Get("b")
+	if k.Get("a") != a {
+		t.Fatal("Get returned a new limiter for a live key")
+	}
+	k.Get("c") // over capacity: b is least recently used
+
+	if n := k.Len(); n != 2 {
+		t.Errorf("Len = %d, want capacity 2", n)
+	}
+	if k.Get("a") != a {
+		t.Error("recently used a was evicted")
+	}
+	if k.Get("b") == b {
+		t.Error("b survived eviction")
+	}
+}
+
+func TestKeyedLimiterDropsIdleKeys(t *testing.T) {
+	const idle = 40 * time.Millisecond
+	k := NewKeyedLimiter(1, 1, 10, idle)
+
+	stale := k.Get("stale")
+	fresh := k.Get("fresh")
+	time.Sleep(idle / 2)
+	k.Get("fresh")
+	time.Sleep(idle/2 + 10*time.Millisecond)
+
+	k.Get("other") // any access sweeps idle keys
+	if n := k.Len(); n != 2 {
+		t.Errorf("Len = %d, want stale dropped", n)
+	}
+	if k.Get("fresh") != fresh {
+		t.Error("fresh was dropped while in use")
+	}
+	if k.Get("stale") == stale {
+		t.Error("stale kept past its idle TTL")
+	}
+}
+
+func TestWaitForTokensCancelsLongWaits(t *testing.T) {
+	d := &Dispatcher{
+		// One token, then one every 10s: far past maxRateWait
+		limiters:        map[Channel]*RateLimiter{ChannelSMS: NewRateLimiter(1, 0.1)},
+		recipientLimits: NewKeyedLimiter(2, 0.1, 10, time.Minute),
+		ctx:             context.Background(),
+	}
+	notif := &Notification{Type: ChannelSMS, Recipient: Recipient{UserID: "u1"}}
+
+	if delay, err := d.waitForTokens(notif); delay != 0 || err != nil {
+		t.Fatalf("first send: delay %v, err %v", delay, err)
+	}
+
+	delay, err := d.waitForTokens(notif)
+	if err != nil || delay <= maxRateWait || delay > 10*time.Second {
+		t.Fatalf("second send: delay %v, err %v, want deferred about 10s", delay, err)
+	}
+
+	// Deferring handed both tokens back: the recipient still has its
+	// second one, and the channel's next token is not pushed out further
+	if !d.recipientLimits.Get("u1").Allow() {
+		t.Error("deferred send kept the recipient's token")
+	}
+	if delay, _ := d.waitForTokens(notif); delay > 10*time.Second {
+		t.Errorf("third send deferred %v, want the cancelled token reused", delay)
+	}
+}
+
+func TestWaitForTokensSleepsShortWaits(t *testing.T) {
+	d := &Dispatcher{
+		limiters:        map[Channel]*RateLimiter{ChannelEmail: NewRateLimiter(1, 20)},
+		recipientLimits: NewKeyedLimiter(1, 20, 10, time.Minute),
+		ctx:             context.Background(),
+	}
+	notif := &Notification{Type: ChannelEmail, Recipient: Recipient{UserID: "u1"}}
+
+	d.waitForTokens(notif)
+	start := time.Now()
+	if delay, err := d.waitForTokens(notif); delay != 0 || err != nil {
+		t.Fatalf("delay %v, err %v, want the worker to wait in place", delay, err)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("returned after %v, want about 50ms", waited)
+	}
+
+	// Shutdown interrupts the wait and gives the tokens back
+	ctx, cancel := context.WithCancel(context.Background())
+	d.ctx = ctx
+	cancel()
+	if _, err := d.waitForTokens(notif); err == nil {
+		t.Error("waitForTokens ignored shutdown")
+	}
+}
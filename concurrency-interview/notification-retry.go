@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// maxAttemptBudget caps sends plus rate-limit deferrals, so a notification
+// stuck behind a saturated limiter eventually gives up
+const maxAttemptBudget = 10
+
+// --------------------
+// DelayQueue
+// --------------------
+
+// DelayQueue holds notifications until their retry time, and keeps the
+// pending set in a RetryStore if one is set
+type DelayQueue struct {
+	queue *delayQueue[*Notification]
+	store RetryStore // optional, guarded by queue.mu; nil keeps retries in memory only
+}
+
+func NewDelayQueue() *DelayQueue {
+	q := &DelayQueue{queue: newDelayQueue[*Notification]()}
+	q.queue.onChange = q.persist
+	return q
+}
+
+// Schedule queues notif to be released at due
+func (q *DelayQueue) Schedule(notif *Notification, due time.Time) {
+	q.queue.schedule(notif, due)
+}
+
+func (q *DelayQueue) Len() int {
+	return q.queue.Len()
+}
+
+// Run releases due notifications to deliver until ctx is done. Items not
+// yet due at shutdown stay in the store, if one is configured.
+func (q *DelayQueue) Run(ctx context.Context, deliver func(*Notification) bool) {
+	q.queue.run(ctx, nil, deliver)
+}
+
+func (q *DelayQueue) setStore(store RetryStore) {
+	q.queue.mu.Lock()
+	defer q.queue.mu.Unlock()
+	q.store = store
+}
+
+// persist is the queue's onChange hook
+func (q *DelayQueue) persist(items []delayed[*Notification]) {
+	if q.store == nil {
+		return
+	}
+	pending := make([]PendingRetry, len(items))
+	for i, item := range items {
+		pending[i] = PendingRetry{Notification: item.value, Due: item.due}
+	}
+	if err := q.store.Save(pending); err != nil {
+		log.Printf("Retry store: %v", err)
+	}
+}
+
+// --------------------
+// RetryStore
+// --------------------
+
+// PendingRetry is a scheduled retry as persisted by a RetryStore
+type PendingRetry struct {
+	Notification *Notification
+	Due          time.Time
+}
+
+// RetryStore persists the set of pending retries
+type RetryStore interface {
+	Save(pending []PendingRetry) error
+	Load() ([]PendingRetry, error)
+}
+
+// FileRetryStore snapshots pending retries to a JSON file, replacing it
+// atomically on every change. Fine for hundreds of pending retries;
+// use a log-structured store beyond that.
+type FileRetryStore struct {
+	path string
+}
+
+func NewFileRetryStore(path string) *FileRetryStore {
+	return &FileRetryStore{path: path}
+}
+
+func (s *FileRetryStore) Save(pending []PendingRetry) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write retry store: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *FileRetryStore) Load() ([]PendingRetry, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read retry store: %w", err)
+	}
+	var pending []PendingRetry
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, fmt.Errorf("parse retry store: %w", err)
+	}
+	return pending, nil
+}
+
+// SetRetryStore makes pending retries durable and reschedules any that
+// were saved by a previous run. Overdue retries are released immediately.
+func (d *Dispatcher) SetRetryStore(store RetryStore) error {
+	pending, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	d.retries.setStore(store)
+
+	for _, p := range pending {
+		d.retries.Schedule(p.Notification, p.Due)
+	}
+	if len(pending) > 0 {
+		log.Printf("Restored %d pending retries", len(pending))
+	}
+	return nil
+}
+
+// --------------------
+// Dispatcher retry scheduling
+// --------------------
+
+// scheduleRetry queues a failed send with exponential backoff: 1s, 2s, 4s
+func (d *Dispatcher) scheduleRetry(notif *Notification) {
+	backoff := time.Duration(1<<uint(notif.Attempts)) * time.Second
+	d.retries.Schedule(notif, time.Now().Add(backoff))
+}
+
+// scheduleDeferral re-queues a rate-limited notification for when the
+// limiters will next have a token. Deferrals count against the attempt
+// budget so a saturated channel can't loop a notification forever; it
+// returns false once the budget is spent.
+func (d *Dispatcher) scheduleDeferral(workerID int, notif *Notification, wait time.Duration) bool {
+	notif.Deferrals++
+	if notif.Attempts+notif.Deferrals >= maxAttemptBudget {
+		log.Printf("Worker %d: Attempt budget exhausted for %s (%d sends, %d deferrals)",
+			workerID, notif.ID, notif.Attempts, notif.Deferrals)
+		return false
+	}
+
+	d.retries.Schedule(notif, time.Now().Add(wait))
+	return true
+}
+
+// releaseRetry hands a due retry back to the workers
+func (d *Dispatcher) releaseRetry(notif *Notification) bool {
+	select {
+	case d.jobs <- notif:
+		return true
+	case <-d.ctx.Done():
+		return false
+	}
+}
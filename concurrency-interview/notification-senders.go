@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Channel is a delivery channel a Notification is sent over
+type Channel string
+
+const (
+	ChannelEmail Channel = "email"
+	ChannelSMS   Channel = "sms"
+	ChannelPush  Channel = "push"
+)
+
+// Sender delivers a notification over one channel
+type Sender interface {
+	Send(ctx context.Context, notif *Notification) error
+}
+
+// SendError classifies a provider failure. Retryable errors are worth
+// another attempt (timeouts, 5xx, 4xx SMTP); permanent ones are not
+// (bad recipient, 4xx HTTP, rejected content).
+type SendError struct {
+	Provider  string
+	Retryable bool
+	Err       error
+}
+
+func (e *SendError) Error() string {
+	kind := "permanent"
+	if e.Retryable {
+		kind = "retryable"
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Provider, kind, e.Err)
+}
+
+func (e *SendError) Unwrap() error { return e.Err }
+
+func retryable(provider string, err error) error {
+	return &SendError{Provider: provider, Retryable: true, Err: err}
+}
+
+func permanent(provider string, err error) error {
+	return &SendError{Provider: provider, Retryable: false, Err: err}
+}
+
+// IsRetryable reports whether err is worth retrying. Unclassified errors
+// are treated as retryable so unknown failures aren't silently dropped.
+func IsRetryable(err error) bool {
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr.Retryable
+	}
+	return true
+}
+
+// --------------------
+// SimulatedSender
+// --------------------
+
+// SimulatedSender mimics a provider with a fixed latency and 20% transient
+// failure rate. It is the default for every channel.
+type SimulatedSender struct {
+	Name string
+}
+
+func (s SimulatedSender) Send(ctx context.Context, notif *Notification) error {
+	select {
+	case <-time.After(50 * time.Millisecond):
+	case <-ctx.Done():
+		return retryable(s.Name, ctx.Err())
+	}
+	if time.Now().UnixNano()%5 == 0 {
+		return retryable(s.Name, fmt.Errorf("simulated failure"))
+	}
+	return nil
+}
+
+// --------------------
+// SMTPSender (email)
+// --------------------
+
+// SMTPSender delivers to Recipient.Email, as multipart/alternative when
+// the rendered message has an HTML part.
+type SMTPSender struct {
+	Addr string // host:port
+	From string
+	Auth smtp.Auth // optional
+}
+
+func (s *SMTPSender) Send(ctx context.Context, notif *Notification) error {
+	to := notif.Recipient.Email
+	if to == "" {
+		return permanent("smtp", fmt.Errorf("recipient has no email address"))
+	}
+	if strings.ContainsAny(to, "\r\n") {
+		return permanent("smtp", fmt.Errorf("recipient address contains a line break"))
+	}
+	msg := renderedOrMessage(notif)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return retryable("smtp", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, _ := net.SplitHostPort(s.Addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return classifySMTP(err)
+	}
+	defer client.Close()
+
+	if s.Auth != nil {
+		if err := client.Auth(s.Auth); err != nil {
+			return classifySMTP(err)
+		}
+	}
+	if err := client.Mail(s.From); err != nil {
+		return classifySMTP(err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return classifySMTP(err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return classifySMTP(err)
+	}
+	writeMIME(w, s.From, to, msg)
+	if err := w.Close(); err != nil {
+		return classifySMTP(err)
+	}
+
+	// The server has accepted the message; failing now would only get it
+	// sent twice
+	if err := client.Quit(); err != nil {
+		log.Printf("smtp: quit after delivering %s: %v", notif.ID, err)
+	}
+	return nil
+}
+
+// headerSafe folds line breaks into spaces so a value can't start a new
+// header
+var headerSafe = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+func writeMIME(w io.Writer, from, to string, msg *RenderedMessage) {
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n",
+		headerSafe.Replace(from), headerSafe.Replace(to), headerSafe.Replace(msg.Subject))
+	if msg.HTML == "" {
+		fmt.Fprintf(w, "Content-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", msg.Text)
+		return
+	}
+
+	const boundary = "notification-alt"
+	fmt.Fprintf(w, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+	fmt.Fprintf(w, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, msg.Text)
+	fmt.Fprintf(w, "--%s\r\nContent-Type: text/html; charset=utf-8\r\n\r\n%s\r\n", boundary, msg.HTML)
+	fmt.Fprintf(w, "--%s--\r\n", boundary)
+}
+
+// renderedOrMessage returns what the worker rendered, or the raw Message
+// for senders called directly.
+func renderedOrMessage(notif *Notification) *RenderedMessage {
+	if notif.Rendered != nil {
+		return notif.Rendered
+	}
+	return &RenderedMessage{Subject: "Notification " + notif.ID, Text: notif.Message}
+}
+
+// classifySMTP maps SMTP reply codes: 4xx is transient, 5xx is permanent.
+func classifySMTP(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return permanent("smtp", err)
+	}
+	return retryable("smtp", err)
+}
+
+// --------------------
+// WebhookSender (SMS, push)
+// --------------------
+
+// WebhookSender POSTs a JSON body to an HTTP provider. Body builds the
+// provider-specific payload; an error from it is a permanent failure.
+type WebhookSender struct {
+	Name   string
+	URL    string
+	Client *http.Client
+	Body   func(notif *Notification) (interface{}, error)
+}
+
+func NewSMSWebhook(url string) *WebhookSender {
+	return &WebhookSender{
+		Name: "sms",
+		URL:  url,
+		Body: func(n *Notification) (interface{}, error) {
+			if n.Recipient.Phone == "" {
+				return nil, fmt.Errorf("recipient has no phone number")
+			}
+			return map[string]string{
+				"id":   n.ID,
+				"to":   n.Recipient.Phone,
+				"body": renderedOrMessage(n).Text,
+			}, nil
+		},
+	}
+}
+
+func NewPushWebhook(url string) *WebhookSender {
+	return &WebhookSender{
+		Name: "push",
+		URL:  url,
+		Body: func(n *Notification) (interface{}, error) {
+			if n.Recipient.DeviceToken == "" {
+				return nil, fmt.Errorf("recipient has no device token")
+			}
+			msg := renderedOrMessage(n)
+			return map[string]interface{}{
+				"id":    n.ID,
+				"token": n.Recipient.DeviceToken,
+				"notification": map[string]string{
+					"title": msg.Subject,
+					"body":  msg.Text,
+				},
+			}, nil
+		},
+	}
+}
+
+func (s *WebhookSender) Send(ctx context.Context, notif *Notification) error {
+	body, err := s.Body(notif)
+	if err != nil {
+		return permanent(s.Name, err)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return permanent(s.Name, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return permanent(s.Name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return retryable(s.Name, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return retryable(s.Name, fmt.Errorf("HTTP %d", resp.StatusCode))
+	default:
+		return permanent(s.Name, fmt.Errorf("HTTP %d", resp.StatusCode))
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startFakeSMTP runs a minimal SMTP server on localhost. Recipients
+// containing "bounce" are rejected with 550 (permanent), "busy" with 451;
+// for "noquit" the connection drops instead of answering QUIT. Accepted
+// messages are sent on the returned channel.
+func startFakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeSMTP(conn, messages)
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func serveFakeSMTP(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { fmt.Fprintf(conn, "%s\r\n", s) }
+
+	reply("220 fake-smtp ready")
+	inData, noQuit := false, false
+	var data strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if inData {
+			if line == "." {
+				inData = false
+				messages <- data.String()
+				reply("250 OK queued")
+			} else {
+				data.WriteString(line + "\n")
+			}
+			continue
+		}
+
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 fake-smtp")
+		case strings.HasPrefix(cmd, "MAIL FROM"):
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO"):
+			switch {
+			case strings.Contains(line, "bounce"):
+				reply("550 no such user")
+			case strings.Contains(line, "busy"):
+				reply("451 try again later")
+			case strings.Contains(line, "noquit"):
+				noQuit = true
+				reply("250 OK")
+			default:
+				reply("250 OK")
+			}
+		case cmd == "DATA":
+			inData = true
+			reply("354 end with .")
+		case cmd == "QUIT":
+			if noQuit {
+				return
+			}
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// newFakeWebhook answers every request with status, and 400 for bodies
+// that aren't JSON
+func newFakeWebhook(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// checkSendError asserts err's classification: nil, or a SendError that
+// is retryable or not
+func checkSendError(t *testing.T, err error, wantErr, wantRetryable bool) {
+	t.Helper()
+	if !wantErr {
+		if err != nil {
+			t.Fatalf("Send = %v, want success", err)
+		}
+		return
+	}
+
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		t.Fatalf("Send = %v, want a SendError", err)
+	}
+	if sendErr.Retryable != wantRetryable || IsRetryable(err) != wantRetryable {
+		t.Errorf("Send = %v, retryable %v, want %v", err, sendErr.Retryable, wantRetryable)
+	}
+}
+
+func TestSMTPSenderClassifiesErrors(t *testing.T) {
+	addr, _ := startFakeSMTP(t)
+
+	// A port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		name          string
+		addr          string
+		email         string
+		wantErr       bool
+		wantRetryable bool
+	}{
+		{name: "delivered", addr: addr, email: "user@example.com"},
+		{name: "4xx is transient", addr: addr, email: "busy@example.com", wantErr: true, wantRetryable: true},
+		{name: "5xx is permanent", addr: addr, email: "bounce@example.com", wantErr: true},
+		{name: "no address", addr: addr, wantErr: true},
+		{name: "header in address", addr: addr, email: "user@example.com\r\nBcc: victim@example.com", wantErr: true},
+		{name: "quit fails after delivery", addr: addr, email: "noquit@example.com"},
+		{name: "connection refused", addr: closedAddr, email: "user@example.com", wantErr: true, wantRetryable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &SMTPSender{Addr: tt.addr, From: "noreply@example.com"}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			err := sender.Send(ctx, &Notification{
+				ID:        "n1",
+				Recipient: Recipient{Email: tt.email},
+				Rendered:  &RenderedMessage{Subject: "Hi", Text: "text", HTML: "<p>html</p>"},
+			})
+			checkSendError(t, err, tt.wantErr, tt.wantRetryable)
+		})
+	}
+}
+
+func TestSMTPSenderKeepsSubjectOnOneLine(t *testing.T) {
+	addr, messages := startFakeSMTP(t)
+	sender := &SMTPSender{Addr: addr, From: "noreply@example.com"}
+
+	err := sender.Send(context.Background(), &Notification{
+		ID:        "n1",
+		Recipient: Recipient{Email: "user@example.com"},
+		Rendered:  &RenderedMessage{Subject: "Hi\r\nBcc: victim@example.com", Text: "text"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-messages
+	headers, _, _ := strings.Cut(msg, "\n\n")
+	for _, line := range strings.Split(headers, "\n") {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Fatalf("subject injected a header:\n%s", headers)
+		}
+	}
+	if !strings.Contains(headers, "Subject: Hi Bcc: victim@example.com") {
+		t.Errorf("subject not folded onto one line:\n%s", headers)
+	}
+}
+
+func TestWebhookSenderClassifiesErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		phone         string
+		wantErr       bool
+		wantRetryable bool
+	}{
+		{name: "accepted", status: http.StatusAccepted, phone: "+15550000"},
+		{name: "5xx is transient", status: http.StatusServiceUnavailable, phone: "+15550000", wantErr: true, wantRetryable: true},
+		{name: "429 is transient", status: http.StatusTooManyRequests, phone: "+15550000", wantErr: true, wantRetryable: true},
+		{name: "4xx is permanent", status: http.StatusBadRequest, phone: "+15550000", wantErr: true},
+		{name: "no phone", status: http.StatusAccepted, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeWebhook(t, tt.status)
+			sender := NewSMSWebhook(srv.URL)
+
+			err := sender.Send(context.Background(), &Notification{
+				ID:        "n1",
+				Recipient: Recipient{Phone: tt.phone},
+				Message:   "hello",
+			})
+			checkSendError(t, err, tt.wantErr, tt.wantRetryable)
+		})
+	}
+
+	t.Run("unreachable is transient", func(t *testing.T) {
+		srv := newFakeWebhook(t, http.StatusAccepted)
+		srv.Close()
+		err := NewPushWebhook(srv.URL).Send(context.Background(), &Notification{
+			ID:        "n1",
+			Recipient: Recipient{DeviceToken: "device-1"},
+		})
+		checkSendError(t, err, true, true)
+	})
+}
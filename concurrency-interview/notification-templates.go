@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"unicode/utf16"
+)
+
+// Recipient is who a Notification is addressed to. Each channel uses the
+// field it needs: Email for email, Phone for SMS, DeviceToken for push.
+type Recipient struct {
+	UserID      string
+	Name        string
+	Email       string
+	Phone       string
+	DeviceToken string
+}
+
+// TemplateVariant is the source for one channel's rendering of a template.
+// Email uses Subject, Text and HTML (HTML is escaped via html/template);
+// SMS uses Text; push uses Subject as the title and Text as the body.
+type TemplateVariant struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// RenderedMessage is a template rendered for one recipient
+type RenderedMessage struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// TemplateData is what templates see: {{.Recipient.Name}}, {{.Vars.code}}
+type TemplateData struct {
+	Recipient Recipient
+	Vars      map[string]interface{}
+}
+
+// SMS bodies are kept to a single segment: 160 characters from the GSM-7
+// alphabet, or 70 UTF-16 units once any other character forces UCS-2
+const (
+	smsMaxGSM7 = 160
+	smsMaxUCS2 = 70
+)
+
+const (
+	gsm7Basic    = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extended = "\f^{}\\[~]|€" // sent as an escape plus one character
+)
+
+// smsLength returns how much of a segment s takes and the segment's size
+// in its encoding
+func smsLength(s string) (n, limit int, encoding string) {
+	for _, r := range s {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			n++
+		case strings.ContainsRune(gsm7Extended, r):
+			n += 2
+		default:
+			n = 0
+			for _, r := range s {
+				n += utf16.RuneLen(r)
+			}
+			return n, smsMaxUCS2, "UCS-2"
+		}
+	}
+	return n, smsMaxGSM7, "GSM-7"
+}
+
+type compiledVariant struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// TemplateStore holds compiled templates by ID and channel
+type TemplateStore struct {
+	mu        sync.RWMutex
+	templates map[string]map[Channel]*compiledVariant
+}
+
+func NewTemplateStore() *TemplateStore {
+	return &TemplateStore{templates: make(map[string]map[Channel]*compiledVariant)}
+}
+
+// Register compiles and stores the variant of template id for a channel.
+// Missing variables are errors at render time rather than "<no value>".
+func (s *TemplateStore) Register(id string, ch Channel, v TemplateVariant) error {
+	cv := &compiledVariant{}
+	var err error
+
+	name := fmt.Sprintf("%s/%s", id, ch)
+	if v.Subject != "" {
+		if cv.subject, err = texttemplate.New(name + "/subject").Option("missingkey=error").Parse(v.Subject); err != nil {
+			return fmt.Errorf("template %s: %w", name, err)
+		}
+	}
+	if v.Text != "" {
+		if cv.text, err = texttemplate.New(name + "/text").Option("missingkey=error").Parse(v.Text); err != nil {
+			return fmt.Errorf("template %s: %w", name, err)
+		}
+	}
+	if v.HTML != "" {
+		if cv.html, err = htmltemplate.New(name + "/html").Option("missingkey=error").Parse(v.HTML); err != nil {
+			return fmt.Errorf("template %s: %w", name, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.templates[id] == nil {
+		s.templates[id] = make(map[Channel]*compiledVariant)
+	}
+	s.templates[id][ch] = cv
+	return nil
+}
+
+// Render executes template id's variant for ch against data
+func (s *TemplateStore) Render(id string, ch Channel, data TemplateData) (*RenderedMessage, error) {
+	s.mu.RLock()
+	cv, ok := s.templates[id][ch]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no %s variant for template %q", ch, id)
+	}
+
+	var out RenderedMessage
+	var buf bytes.Buffer
+
+	if cv.subject != nil {
+		if err := cv.subject.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		out.Subject = buf.String()
+		buf.Reset()
+	}
+	if cv.text != nil {
+		if err := cv.text.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		out.Text = buf.String()
+		buf.Reset()
+	}
+	if cv.html != nil {
+		if err := cv.html.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		out.HTML = buf.String()
+	}
+
+	if ch == ChannelSMS {
+		if n, limit, encoding := smsLength(out.Text); n > limit {
+			return nil, fmt.Errorf("sms body is %d %s characters, limit %d for one segment", n, encoding, limit)
+		}
+	}
+	return &out, nil
+}
+
+// render produces the message for notif. Notifications without a template
+// fall back to their preformatted Message.
+func (d *Dispatcher) render(notif *Notification) (*RenderedMessage, error) {
+	if notif.TemplateID == "" {
+		return &RenderedMessage{
+			Subject: "Notification " + notif.ID,
+			Text:    notif.Message,
+		}, nil
+	}
+
+	msg, err := d.templates.Render(notif.TemplateID, notif.Type, TemplateData{
+		Recipient: notif.Recipient,
+		Vars:      notif.Vars,
+	})
+	if err != nil {
+		return nil, permanent("template", err)
+	}
+	return msg, nil
+}
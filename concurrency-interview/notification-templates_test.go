@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplateStoreRendersPerRecipient(t *testing.T) {
+	s := NewTemplateStore()
+	err := s.Register("shipped", ChannelEmail, TemplateVariant{
+		Subject: "Order {{.Vars.order}} shipped",
+		Text:    "Hi {{.Recipient.Name}}, order {{.Vars.order}} is on its way.",
+		HTML:    "<p>Hi {{.Recipient.Name}}</p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		data     TemplateData
+		wantSubj string
+		wantText string
+		wantHTML string
+	}{
+		{
+			name:     "alice",
+			data:     TemplateData{Recipient: Recipient{Name: "Alice"}, Vars: map[string]interface{}{"order": 1001}},
+			wantSubj: "Order 1001 shipped",
+			wantText: "Hi Alice, order 1001 is on its way.",
+			wantHTML: "<p>Hi Alice</p>",
+		},
+		{
+			name:     "html is escaped",
+			data:     TemplateData{Recipient: Recipient{Name: "<b>Bob</b>"}, Vars: map[string]interface{}{"order": "A-7"}},
+			wantSubj: "Order A-7 shipped",
+			wantText: "Hi <b>Bob</b>, order A-7 is on its way.",
+			wantHTML: "<p>Hi &lt;b&gt;Bob&lt;/b&gt;</p>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := s.Render("shipped", ChannelEmail, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Subject != tt.wantSubj || msg.Text != tt.wantText || msg.HTML != tt.wantHTML {
+				t.Errorf("rendered %+v", msg)
+			}
+		})
+	}
+
+	if _, err := s.Render("shipped", ChannelSMS, TemplateData{}); err == nil {
+		t.Error("rendered a channel with no variant")
+	}
+}
+
+func TestTemplateStoreLimitsSMSToOneSegment(t *testing.T) {
+	s := NewTemplateStore()
+	if err := s.Register("raw", ChannelSMS, TemplateVariant{Text: "{{.Vars.body}}"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string // "" if it fits
+	}{
+		{name: "160 GSM-7", body: strings.Repeat("a", 160)},
+		{name: "161 GSM-7", body: strings.Repeat("a", 161), wantErr: "161 GSM-7 characters, limit 160"},
+		{name: "accented letters stay GSM-7", body: strings.Repeat("é", 160)},
+		{name: "extension characters count twice", body: strings.Repeat("€", 81), wantErr: "162 GSM-7 characters"},
+		{name: "70 UCS-2", body: strings.Repeat("ж", 70)},
+		{name: "one character forces UCS-2", body: strings.Repeat("a", 70) + "ж", wantErr: "71 UCS-2 characters, limit 70"},
+		{name: "emoji take two units", body: strings.Repeat("😀", 36), wantErr: "72 UCS-2 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Render("raw", ChannelSMS, TemplateData{Vars: map[string]interface{}{"body": tt.body}})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Render = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Render = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderMissingVariableIsPermanent(t *testing.T) {
+	d := NewDispatcher(1)
+	defer d.Shutdown(time.Second)
+	err := d.RegisterTemplate("otp", ChannelSMS, TemplateVariant{Text: "Your code is {{.Vars.code}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := d.render(&Notification{ID: "n1", Type: ChannelSMS, TemplateID: "otp", Vars: map[string]interface{}{"code": "123456"}})
+	if err != nil || msg.Text != "Your code is 123456" {
+		t.Fatalf("render = %+v, %v", msg, err)
+	}
+
+	_, err = d.render(&Notification{ID: "n2", Type: ChannelSMS, TemplateID: "otp", Vars: map[string]interface{}{"pin": "1"}})
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) || IsRetryable(err) {
+		t.Fatalf("render with a missing variable = %v, want a permanent SendError", err)
+	}
+	if !strings.Contains(err.Error(), "code") {
+		t.Errorf("error %q does not name the missing variable", err)
+	}
+}
@@ -3,89 +3,73 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Notification represents a message to send
 type Notification struct {
-	ID       string
-	Type     string // "email", "sms", "push"
-	Message  string
-	Attempts int
-}
-
-// Simple rate limiter using token bucket
-type RateLimiter struct {
-	tokens   int
-	max      int
-	rate     int // tokens per second
-	mu       sync.Mutex
-	lastTime time.Time
-}
-
-func NewRateLimiter(max, rate int) *RateLimiter {
-	return &RateLimiter{
-		tokens:   max,
-		max:      max,
-		rate:     rate,
-		lastTime: time.Now(),
-	}
-}
-
-func (r *RateLimiter) Allow() bool {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	// Refill tokens based on elapsed time
-	now := time.Now()
-	elapsed := now.Sub(r.lastTime).Seconds()
-	tokensToAdd := int(elapsed * float64(r.rate))
-
-	if tokensToAdd > 0 {
-		r.tokens = min(r.max, r.tokens+tokensToAdd)
-		r.lastTime = now
-	}
-
-	// Check if we have tokens
-	if r.tokens > 0 {
-		r.tokens--
-		return true
-	}
-	return false
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
+	ID             string
+	IdempotencyKey string   // dedup key; defaults to ID
+	Type           Channel  // requested channel; preferences may pick another
+	Priority       Priority // high skips quiet hours, low may be batched
+	Recipient      Recipient
+	TemplateID     string                 // rendered per recipient; empty uses Message
+	Vars           map[string]interface{} // template variables
+	Message        string                 // preformatted fallback when TemplateID is empty
+	Attempts       int                    // sends tried
+	Deferrals      int                    // times re-queued by the rate limiter
+
+	Rendered *RenderedMessage `json:"-"` // set by the worker before sending
 }
 
 // Dispatcher manages workers and queues
 type Dispatcher struct {
-	jobs       chan *Notification
-	retryQueue chan *Notification
-	limiters   map[string]*RateLimiter
-	wg         sync.WaitGroup
-	ctx        context.Context
-	cancel     context.CancelFunc
+	jobs            chan *Notification
+	retries         *DelayQueue
+	limiters        map[Channel]*RateLimiter // per provider
+	recipientLimits *KeyedLimiter            // per recipient
+	senders         map[Channel]Sender
+	templates       *TemplateStore
+	tracker         *DeliveryTracker
+	prefs           *PreferenceStore
+	digests         *Digester
+	submitted       atomic.Int64
+	rejected        atomic.Int64
+	wg              sync.WaitGroup
+	ctx             context.Context
+	cancel          context.CancelFunc
 }
 
 func NewDispatcher(workers int) *Dispatcher {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	d := &Dispatcher{
-		jobs:       make(chan *Notification, 100),
-		retryQueue: make(chan *Notification, 50),
-		limiters: map[string]*RateLimiter{
-			"email": NewRateLimiter(100, 10),
-			"sms":   NewRateLimiter(50, 5),
-			"push":  NewRateLimiter(200, 20),
+		jobs:    make(chan *Notification, 100),
+		retries: NewDelayQueue(),
+		limiters: map[Channel]*RateLimiter{
+			ChannelEmail: NewRateLimiter(100, 10),
+			ChannelSMS:   NewRateLimiter(50, 5),
+			ChannelPush:  NewRateLimiter(200, 20),
 		},
-		ctx:    ctx,
-		cancel: cancel,
+		senders: map[Channel]Sender{
+			ChannelEmail: SimulatedSender{Name: "email"},
+			ChannelSMS:   SimulatedSender{Name: "sms"},
+			ChannelPush:  SimulatedSender{Name: "push"},
+		},
+		recipientLimits: NewKeyedLimiter(3, 0.5, 10000, 10*time.Minute),
+		templates:       NewTemplateStore(),
+		tracker:         NewDeliveryTracker(10 * time.Minute),
+		prefs:           NewPreferenceStore(),
+		digests:         NewDigester(),
+		ctx:             ctx,
+		cancel:          cancel,
 	}
 
 	// Start workers
@@ -94,13 +78,32 @@ func NewDispatcher(workers int) *Dispatcher {
 		go d.worker(i)
 	}
 
-	// Start retry handler
+	// Start retry scheduler
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.retries.Run(d.ctx, d.releaseRetry)
+		log.Println("Retry scheduler stopping")
+	}()
+
+	// Start digest flusher
 	d.wg.Add(1)
-	go d.retryHandler()
+	go d.runDigests()
 
 	return d
 }
 
+// RegisterSender installs the provider for a channel. Call it before
+// submitting notifications for that channel.
+func (d *Dispatcher) RegisterSender(ch Channel, sender Sender) {
+	d.senders[ch] = sender
+}
+
+// RegisterTemplate adds a channel variant of a notification template
+func (d *Dispatcher) RegisterTemplate(id string, ch Channel, v TemplateVariant) error {
+	return d.templates.Register(id, ch, v)
+}
+
 func (d *Dispatcher) worker(id int) {
 	defer d.wg.Done()
 
@@ -116,68 +119,102 @@ func (d *Dispatcher) worker(id int) {
 }
 
 func (d *Dispatcher) process(workerID int, notif *Notification) {
-	// Check rate limit
-	limiter := d.limiters[notif.Type]
-	if !limiter.Allow() {
-		log.Printf("Worker %d: Rate limit hit for %s, queuing retry", workerID, notif.Type)
-		d.scheduleRetry(notif)
+	// Skip notifications already delivered or being sent by another worker
+	if !d.tracker.Begin(notif) {
+		log.Printf("Worker %d: Skipping %s, already sent or in flight", workerID, notif.ID)
+		return
+	}
+
+	sender, ok := d.senders[notif.Type]
+	if !ok {
+		log.Printf("Worker %d: No sender for %s, dropping %s", workerID, notif.Type, notif.ID)
+		d.tracker.Record(notif, StateDead, "no sender for channel")
+		return
+	}
+
+	// Render per recipient; template errors are permanent
+	rendered, err := d.render(notif)
+	if err != nil {
+		log.Printf("Worker %d: Failed to render %s: %v", workerID, notif.ID, err)
+		d.tracker.Record(notif, StateDead, err.Error())
+		return
+	}
+	notif.Rendered = rendered
+
+	// Wait for the provider's and the recipient's rate limits
+	wait, err := d.waitForTokens(notif)
+	if err != nil {
+		// Shutting down; keep it so a retry store can persist it
+		d.retries.Schedule(notif, time.Now())
+		d.tracker.Record(notif, StateQueued, "interrupted by shutdown")
+		return
+	}
+	if wait > 0 {
+		log.Printf("Worker %d: Rate limit for %s needs %v, deferring %s", workerID, notif.Type, wait.Round(time.Millisecond), notif.ID)
+		if d.scheduleDeferral(workerID, notif, wait) {
+			d.tracker.Record(notif, StateQueued, "deferred by rate limiter")
+		} else {
+			d.tracker.Record(notif, StateDead, "attempt budget exhausted")
+		}
 		return
 	}
 
-	// Simulate sending (with 20% failure rate)
-	time.Sleep(50 * time.Millisecond)
-	failed := time.Now().UnixNano()%5 == 0
+	ctx, cancel := context.WithTimeout(d.ctx, 5*time.Second)
+	err = sender.Send(ctx, notif)
+	cancel()
 
 	notif.Attempts++
 
-	if failed {
-		log.Printf("Worker %d: Failed to send %s (attempt %d)", workerID, notif.ID, notif.Attempts)
-		if notif.Attempts < 3 {
+	if err != nil {
+		log.Printf("Worker %d: Failed to send %s (attempt %d): %v", workerID, notif.ID, notif.Attempts, err)
+		switch {
+		case !IsRetryable(err):
+			log.Printf("Worker %d: Permanent failure for %s, not retrying", workerID, notif.ID)
+			d.tracker.Record(notif, StateDead, err.Error())
+		case notif.Attempts < 3:
+			d.tracker.Record(notif, StateFailed, err.Error())
 			d.scheduleRetry(notif)
-		} else {
+		default:
 			log.Printf("Worker %d: Max retries reached for %s", workerID, notif.ID)
+			d.tracker.Record(notif, StateDead, err.Error())
 		}
 		return
 	}
 
+	d.tracker.Record(notif, StateSent, "")
 	log.Printf("Worker %d: ✓ Sent %s via %s", workerID, notif.ID, notif.Type)
 }
 
-func (d *Dispatcher) scheduleRetry(notif *Notification) {
-	go func() {
-		// Exponential backoff: 1s, 2s, 4s
-		backoff := time.Duration(1<<uint(notif.Attempts)) * time.Second
-
-		select {
-		case <-time.After(backoff):
-			d.retryQueue <- notif
-		case <-d.ctx.Done():
-		}
-	}()
+// Submit applies the recipient's preferences and queues notif for
+// delivery, waiting for queue space until ctx is done. It returns
+// ErrDuplicate if the same idempotency key was submitted within the dedup
+// window, and ErrNoChannel if the recipient can't be reached on any
+// channel they haven't opted out of.
+func (d *Dispatcher) Submit(ctx context.Context, notif *Notification) error {
+	return d.route(ctx, notif, true)
 }
 
-func (d *Dispatcher) retryHandler() {
-	defer d.wg.Done()
+// TrySubmit is Submit without waiting: a full queue returns ErrQueueFull
+func (d *Dispatcher) TrySubmit(notif *Notification) error {
+	return d.route(context.Background(), notif, false)
+}
 
-	for {
-		select {
-		case <-d.ctx.Done():
-			log.Println("Retry handler stopping")
-			return
-		case notif := <-d.retryQueue:
-			d.jobs <- notif
-		}
-	}
+// SubmitBatch submits notifs in order, waiting for space like Submit, and
+// returns one error per notification
+func (d *Dispatcher) SubmitBatch(ctx context.Context, notifs []*Notification) []error {
+	return submitBatch(ctx, len(notifs), func(ctx context.Context, i int) error {
+		return d.Submit(ctx, notifs[i])
+	})
 }
 
-func (d *Dispatcher) Submit(notif *Notification) error {
-	select {
-	case d.jobs <- notif:
-		return nil
-	case <-d.ctx.Done():
-		return fmt.Errorf("dispatcher shutting down")
-	default:
-		return fmt.Errorf("queue full")
+// Stats reports queue depth and submission counters. Notifications held
+// for retry, quiet hours or a digest are not in Depth.
+func (d *Dispatcher) Stats() QueueStats {
+	return QueueStats{
+		Depth:     len(d.jobs),
+		Capacity:  cap(d.jobs),
+		Submitted: d.submitted.Load(),
+		Rejected:  d.rejected.Load(),
 	}
 }
 
@@ -203,23 +240,137 @@ func (d *Dispatcher) Shutdown(timeout time.Duration) error {
 func Notifications() {
 	dispatcher := NewDispatcher(5) // 5 workers
 
-	// Submit test notifications
-	types := []string{"email", "sms", "push"}
-	for i := 0; i < 30; i++ {
-		notif := &Notification{
-			ID:      fmt.Sprintf("notif-%d", i),
-			Type:    types[i%3],
-			Message: fmt.Sprintf("Test message %d", i),
+	// NOTIFY_RETRY_STORE=path keeps pending retries across restarts
+	if path := os.Getenv("NOTIFY_RETRY_STORE"); path != "" {
+		if err := dispatcher.SetRetryStore(NewFileRetryStore(path)); err != nil {
+			log.Fatalf("retry store: %v", err)
+		}
+	}
+
+	// Real providers when configured, simulated ones otherwise
+	if addr := os.Getenv("NOTIFY_SMTP_ADDR"); addr != "" {
+		dispatcher.RegisterSender(ChannelEmail, &SMTPSender{Addr: addr, From: "noreply@example.com"})
+	}
+	if url := os.Getenv("NOTIFY_SMS_URL"); url != "" {
+		dispatcher.RegisterSender(ChannelSMS, NewSMSWebhook(url))
+	}
+	if url := os.Getenv("NOTIFY_PUSH_URL"); url != "" {
+		dispatcher.RegisterSender(ChannelPush, NewPushWebhook(url))
+	}
+
+	// One template, a variant per channel
+	variants := map[Channel]TemplateVariant{
+		ChannelEmail: {
+			Subject: "Your order {{.Vars.order}} has shipped",
+			Text:    "Hi {{.Recipient.Name}}, order {{.Vars.order}} is on its way.",
+			HTML:    "<p>Hi {{.Recipient.Name}},</p><p>Order <b>{{.Vars.order}}</b> is on its way.</p>",
+		},
+		ChannelSMS:  {Text: "Order {{.Vars.order}} shipped. Track: {{.Vars.url}}"},
+		ChannelPush: {Subject: "Order shipped", Text: "{{.Recipient.Name}}, order {{.Vars.order}} is on its way"},
+	}
+	for ch, v := range variants {
+		if err := dispatcher.RegisterTemplate("order_shipped", ch, v); err != nil {
+			log.Fatalf("template: %v", err)
+		}
+	}
+
+	// Submit test notifications as one batch, waiting up to a second for
+	// queue space
+	types := []Channel{ChannelEmail, ChannelSMS, ChannelPush}
+	batch := make([]*Notification, 30)
+	for i := range batch {
+		batch[i] = &Notification{
+			ID:   fmt.Sprintf("notif-%d", i),
+			Type: types[i%3],
+			Recipient: Recipient{
+				UserID:      fmt.Sprintf("user-%d", i),
+				Name:        fmt.Sprintf("User %d", i),
+				Email:       fmt.Sprintf("user%d@example.com", i),
+				Phone:       fmt.Sprintf("+1555000%04d", i),
+				DeviceToken: fmt.Sprintf("device-%d", i),
+			},
+			TemplateID: "order_shipped",
+			Vars: map[string]interface{}{
+				"order": fmt.Sprintf("A-%04d", i),
+				"url":   fmt.Sprintf("https://t.example.com/A-%04d", i),
+			},
 		}
+	}
+	delete(batch[28].Vars, "url") // missing variable: permanent failure
 
-		if err := dispatcher.Submit(notif); err != nil {
-			log.Printf("Failed to submit: %v", err)
+	submitCtx, cancelSubmit := context.WithTimeout(context.Background(), time.Second)
+	for i, err := range dispatcher.SubmitBatch(submitCtx, batch) {
+		if err != nil {
+			log.Printf("Failed to submit %s: %v", batch[i].ID, err)
+		}
+	}
+	cancelSubmit()
+
+	// Preferences: opt-out with fallback, quiet hours, digest batching
+	now := time.Now().UTC()
+	prefs := []UserPreferences{
+		{UserID: "user-30", OptOut: map[Channel]bool{ChannelEmail: true}},
+		{UserID: "user-31", QuietHours: &QuietHours{
+			Start:    now.Add(-time.Hour).Format("15:04"),
+			End:      now.Add(time.Hour).Format("15:04"),
+			Timezone: "UTC",
+		}},
+		{UserID: "user-32", DigestWindow: 2 * time.Second},
+	}
+	for _, p := range prefs {
+		if err := dispatcher.SetPreferences(p); err != nil {
+			log.Fatalf("preferences: %v", err)
+		}
+	}
+	extra := []*Notification{
+		{ID: "optout-1", Type: ChannelEmail, Message: "Email opted out, falls back to push",
+			Recipient: Recipient{UserID: "user-30", Email: "u30@example.com", DeviceToken: "device-30"}},
+		{ID: "quiet-1", Type: ChannelPush, Message: "Held until quiet hours end",
+			Recipient: Recipient{UserID: "user-31", DeviceToken: "device-31"}},
+		{ID: "urgent-1", Type: ChannelPush, Priority: PriorityHigh, Message: "Urgent, ignores quiet hours",
+			Recipient: Recipient{UserID: "user-31", DeviceToken: "device-31"}},
+	}
+	for i := 0; i < 3; i++ {
+		extra = append(extra, &Notification{
+			ID: fmt.Sprintf("digest-item-%d", i), Type: ChannelEmail, Priority: PriorityLow,
+			Message:   fmt.Sprintf("Someone liked your post #%d", i),
+			Recipient: Recipient{UserID: "user-32", Email: "u32@example.com"},
+		})
+	}
+	for _, notif := range extra {
+		if err := dispatcher.TrySubmit(notif); err != nil {
+			log.Printf("Failed to submit %s: %v", notif.ID, err)
 		}
 	}
 
+	// Resubmitting the same idempotency key is rejected
+	dup := &Notification{ID: "notif-0-again", IdempotencyKey: "notif-0", Type: ChannelEmail}
+	if err := dispatcher.TrySubmit(dup); err != nil {
+		log.Printf("Failed to submit %s: %v", dup.ID, err)
+	}
+
 	// Let it run
 	time.Sleep(8 * time.Second)
 
+	// Delivery history over HTTP
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("status server: %v", err)
+	}
+	go http.Serve(ln, dispatcher.StatusHandler())
+	defer ln.Close()
+	for _, id := range []string{"notif-28", "optout-1", "quiet-1", "digest-item-0"} {
+		if resp, err := http.Get("http://" + ln.Addr().String() + "/notifications/" + id + "/status"); err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			log.Printf("Status of %s: %s", id, body)
+		}
+	}
+
+	stats := dispatcher.Stats()
+	log.Printf("Queue: %d/%d, submitted %d, rejected %d",
+		stats.Depth, stats.Capacity, stats.Submitted, stats.Rejected)
+
 	// Graceful shutdown
 	if err := dispatcher.Shutdown(3 * time.Second); err != nil {
 		log.Printf("Shutdown error: %v", err)
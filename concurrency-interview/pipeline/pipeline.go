@@ -0,0 +1,466 @@
+// Package pipeline builds concurrent processing flows out of stages
+// connected by channels. Every stage runs under one Pipeline: the first
+// stage to fail cancels the rest, every stage closes its output once it
+// stops, and Wait reports the first error.
+//
+//	p := pipeline.New(ctx)
+//	ids := pipeline.FromSlice(p, []int{1, 2, 3})
+//	squares := pipeline.Map(p, ids, 4, func(_ context.Context, n int) (int, error) { return n * n, nil })
+//	sum, err := pipeline.Reduce(p, squares, 0, func(acc, n int) int { return acc + n })
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrSkip, returned by a Map function, drops the item without failing
+// the pipeline
+var ErrSkip = errors.New("pipeline: skip item")
+
+// ErrOutOfOrder fails a MergeOrdered whose inputs weren't each in order
+var ErrOutOfOrder = errors.New("pipeline: input out of order")
+
+// Pipeline owns the goroutines of every stage built on it
+type Pipeline struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+// New starts an empty pipeline. Cancelling ctx stops every stage.
+func New(ctx context.Context) *Pipeline {
+	pctx, cancel := context.WithCancel(ctx)
+	return &Pipeline{parent: ctx, ctx: pctx, cancel: cancel}
+}
+
+// Context is cancelled once the pipeline fails or its parent is done
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// Fail records err, if it is the first, and cancels every stage
+func (p *Pipeline) Fail(err error) {
+	if err == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.mu.Unlock()
+	p.cancel()
+}
+
+// Go runs fn as part of the pipeline; a non-nil error fails it
+func (p *Pipeline) Go(fn func(ctx context.Context) error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := fn(p.ctx); err != nil {
+			p.Fail(err)
+		}
+	}()
+}
+
+// Wait blocks until every stage has stopped and returns the first error,
+// or the parent context's error if it was cancelled
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	return p.parent.Err()
+}
+
+// send delivers v unless ctx is done first
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// recv takes the next item, reporting false once in is closed or ctx is
+// done
+func recv[T any](ctx context.Context, in <-chan T) (T, bool) {
+	select {
+	case v, ok := <-in:
+		return v, ok
+	case <-ctx.Done():
+		var zero T
+		return zero, false
+	}
+}
+
+// --------------------
+// Stages
+// --------------------
+
+// Source runs gen, which emits items until it returns. emit reports false
+// once the pipeline is cancelled, and gen should then return.
+func Source[T any](p *Pipeline, gen func(ctx context.Context, emit func(T) bool) error) <-chan T {
+	out := make(chan T)
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		return gen(ctx, func(v T) bool { return send(ctx, out, v) })
+	})
+	return out
+}
+
+// FromSlice emits items in order
+func FromSlice[T any](p *Pipeline, items []T) <-chan T {
+	return Source(p, func(ctx context.Context, emit func(T) bool) error {
+		for _, v := range items {
+			if !emit(v) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Map applies fn to every item using the given number of workers. Output
+// order is not preserved when workers > 1. An error fails the pipeline,
+// except ErrSkip, which drops the item.
+func Map[T, U any](p *Pipeline, in <-chan T, workers int, fn func(context.Context, T) (U, error)) <-chan U {
+	out := make(chan U)
+	var wg sync.WaitGroup
+
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		p.Go(func(ctx context.Context) error {
+			defer wg.Done()
+			for {
+				v, ok := recv(ctx, in)
+				if !ok {
+					return nil
+				}
+				u, err := fn(ctx, v)
+				if errors.Is(err, ErrSkip) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if !send(ctx, out, u) {
+					return nil
+				}
+			}
+		})
+	}
+
+	p.Go(func(context.Context) error {
+		wg.Wait()
+		close(out)
+		return nil
+	})
+	return out
+}
+
+// Item carries a value or the error that kept it from being produced,
+// for flows that report per-item failures instead of failing outright
+type Item[T any] struct {
+	Value T
+	Err   error
+}
+
+// MapItems is Map over Items: fn runs on successful items only, its errors
+// become failed items, and failed items pass through unchanged. Only
+// cancellation stops it early.
+func MapItems[T, U any](p *Pipeline, in <-chan Item[T], workers int, fn func(context.Context, T) (U, error)) <-chan Item[U] {
+	return Map(p, in, workers, func(ctx context.Context, item Item[T]) (Item[U], error) {
+		if item.Err != nil {
+			return Item[U]{Err: item.Err}, nil
+		}
+		u, err := fn(ctx, item.Value)
+		return Item[U]{Value: u, Err: err}, nil
+	})
+}
+
+// Filter passes on the items keep returns true for
+func Filter[T any](p *Pipeline, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		for {
+			v, ok := recv(ctx, in)
+			if !ok {
+				return nil
+			}
+			if keep(v) && !send(ctx, out, v) {
+				return nil
+			}
+		}
+	})
+	return out
+}
+
+// Batch groups items into slices of up to size. With maxWait > 0 a
+// partial batch is emitted once its first item has waited that long.
+func Batch[T any](p *Pipeline, in <-chan T, size int, maxWait time.Duration) <-chan []T {
+	out := make(chan []T)
+	size = max(size, 1)
+
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+
+		var batch []T
+		var deadline <-chan time.Time
+		var timer *time.Timer
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		flush := func() bool {
+			if len(batch) == 0 {
+				return true
+			}
+			b := batch
+			batch = nil
+			deadline = nil
+			return send(ctx, out, b)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-deadline:
+				if !flush() {
+					return nil
+				}
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return nil
+				}
+				batch = append(batch, v)
+				if len(batch) == 1 && maxWait > 0 {
+					if timer == nil {
+						timer = time.NewTimer(maxWait)
+					} else {
+						timer.Reset(maxWait)
+					}
+					deadline = timer.C
+				}
+				if len(batch) == size && !flush() {
+					return nil
+				}
+			}
+		}
+	})
+	return out
+}
+
+// FanOut splits in across n outputs. Each item goes to exactly one
+// output, whichever is ready first, so a slow consumer gets fewer items.
+func FanOut[T any](p *Pipeline, in <-chan T, n int) []<-chan T {
+	outs := make([]<-chan T, max(n, 1))
+	for i := range outs {
+		out := make(chan T)
+		outs[i] = out
+		p.Go(func(ctx context.Context) error {
+			defer close(out)
+			for {
+				v, ok := recv(ctx, in)
+				if !ok || !send(ctx, out, v) {
+					return nil
+				}
+			}
+		})
+	}
+	return outs
+}
+
+// Merge combines several inputs into one output, in arrival order
+func Merge[T any](p *Pipeline, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+
+	for _, in := range ins {
+		wg.Add(1)
+		p.Go(func(ctx context.Context) error {
+			defer wg.Done()
+			for {
+				v, ok := recv(ctx, in)
+				if !ok || !send(ctx, out, v) {
+					return nil
+				}
+			}
+		})
+	}
+
+	p.Go(func(context.Context) error {
+		wg.Wait()
+		close(out)
+		return nil
+	})
+	return out
+}
+
+// MergeOrdered combines inputs into one output in sequence order,
+// starting at first. seq numbers each item. At most window items beyond
+// the next expected one are buffered; an input whose item doesn't fit is
+// paused until it does, which pushes back on its producer.
+//
+// Each input must deliver its own items in increasing order. Map with
+// more than one worker doesn't, nor does anything fed by several
+// goroutines; give each input a single producer, or number items where
+// their order is still known. Once every open input is paused on a later
+// item, the missing numbers can't arrive and are skipped as gaps, so
+// failed or filtered items never stall the merge.
+//
+// An item that breaks that order and turns up after its number was
+// skipped is never emitted. It goes to late if set; otherwise the
+// pipeline fails with ErrOutOfOrder.
+func MergeOrdered[T any](p *Pipeline, seq func(T) int, first, window int, late func(T), ins ...<-chan T) <-chan T {
+	type arrival struct {
+		input  int
+		v      T
+		closed bool
+	}
+
+	out := make(chan T)
+	arrivals := make(chan arrival)
+	acks := make([]chan struct{}, len(ins))
+	window = max(window, 1)
+
+	// One forwarder per input; each waits for its item to be accepted
+	// before reading the next
+	for i, in := range ins {
+		acks[i] = make(chan struct{}, 1)
+		p.Go(func(ctx context.Context) error {
+			for {
+				v, ok := recv(ctx, in)
+				if !ok {
+					send(ctx, arrivals, arrival{input: i, closed: true})
+					return nil
+				}
+				if !send(ctx, arrivals, arrival{input: i, v: v}) {
+					return nil
+				}
+				select {
+				case <-acks[i]:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		})
+	}
+
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+
+		next := first
+		buffered := make(map[int]T)
+		held := make(map[int]T) // paused inputs and the item they hold
+		open := len(ins)
+
+		// drop handles an item whose number was already passed
+		drop := func(v T) {
+			if late != nil {
+				late(v)
+				return
+			}
+			p.Fail(fmt.Errorf("%w: item %d after %d", ErrOutOfOrder, seq(v), next-1))
+		}
+
+		for {
+			// Emit the run that is ready, then let paused inputs in
+			progress := true
+			for progress {
+				progress = false
+				for v, ok := buffered[next]; ok; v, ok = buffered[next] {
+					delete(buffered, next)
+					next++
+					if !send(ctx, out, v) {
+						return nil
+					}
+				}
+				for i, v := range held {
+					s := seq(v)
+					if s >= next+window {
+						continue
+					}
+					delete(held, i)
+					acks[i] <- struct{}{}
+					progress = true
+					if s < next {
+						drop(v)
+					} else {
+						buffered[s] = v
+					}
+				}
+			}
+
+			// Every open input is waiting on a later item: skip the gap
+			if len(held) == open {
+				if len(buffered) == 0 && len(held) == 0 {
+					return nil // all inputs closed and drained
+				}
+				lowest := math.MaxInt
+				for s := range buffered {
+					lowest = min(lowest, s)
+				}
+				for _, v := range held {
+					lowest = min(lowest, seq(v))
+				}
+				next = lowest
+				continue
+			}
+
+			a, ok := recv(ctx, arrivals)
+			if !ok {
+				return nil
+			}
+			if a.closed {
+				open--
+				continue
+			}
+
+			switch s := seq(a.v); {
+			case s < next: // its gap was already skipped
+				acks[a.input] <- struct{}{}
+				drop(a.v)
+			case s < next+window:
+				buffered[s] = a.v
+				acks[a.input] <- struct{}{}
+			default:
+				held[a.input] = a.v
+			}
+		}
+	})
+	return out
+}
+
+// Reduce folds every item into an accumulator in the calling goroutine,
+// then waits for the pipeline and returns its error. On failure the
+// partial accumulator is returned along with the error.
+func Reduce[T, A any](p *Pipeline, in <-chan T, init A, fn func(A, T) A) (A, error) {
+	acc := init
+	for {
+		v, ok := recv(p.ctx, in)
+		if !ok {
+			break
+		}
+		acc = fn(acc, v)
+	}
+	return acc, p.Wait()
+}
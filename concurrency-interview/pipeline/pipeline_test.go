@@ -0,0 +1,41 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMergeOrderedNeverEmitsLateItems(t *testing.T) {
+	identity := func(n int) int { return n }
+
+	// 5 doesn't fit the window, so with the only input paused on it 1-4
+	// are skipped as gaps; the 1 that follows breaks the input's order
+	t.Run("reported", func(t *testing.T) {
+		p := New(context.Background())
+		var late []int
+		out := MergeOrdered(p, identity, 1, 2, func(n int) { late = append(late, n) }, FromSlice(p, []int{5, 1, 6}))
+
+		got, err := Reduce(p, out, []int(nil), func(acc []int, n int) []int { return append(acc, n) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, []int{5, 6}) || !slices.Equal(late, []int{1}) {
+			t.Errorf("emitted %v, late %v; want [5 6], [1]", got, late)
+		}
+	})
+
+	t.Run("fails without late", func(t *testing.T) {
+		p := New(context.Background())
+		out := MergeOrdered(p, identity, 1, 2, nil, FromSlice(p, []int{5, 1, 6}))
+
+		got, err := Reduce(p, out, []int(nil), func(acc []int, n int) []int { return append(acc, n) })
+		if !errors.Is(err, ErrOutOfOrder) {
+			t.Errorf("err = %v, want ErrOutOfOrder", err)
+		}
+		if slices.Contains(got, 1) {
+			t.Errorf("emitted %v, want 1 dropped", got)
+		}
+	})
+}
@@ -0,0 +1,206 @@
+package pipeline
+
+import (
+	"math"
+	"sort"
+)
+
+// Stats summarizes a stream of values. It is mergeable, so windows can
+// be built from smaller panes.
+type Stats struct {
+	Count int
+	Sum   float64
+	Min   float64
+	Max   float64
+
+	mean   float64
+	m2     float64 // sum of squared deviations from the mean
+	sketch *Sketch
+}
+
+// sketchAccuracy is the relative error of Stats percentiles
+const sketchAccuracy = 0.01
+
+func (s *Stats) Add(v float64) {
+	if s.Count == 0 {
+		s.Min, s.Max = v, v
+	} else {
+		s.Min = math.Min(s.Min, v)
+		s.Max = math.Max(s.Max, v)
+	}
+	s.Count++
+	s.Sum += v
+
+	// Welford's online update
+	delta := v - s.mean
+	s.mean += delta / float64(s.Count)
+	s.m2 += delta * (v - s.mean)
+
+	if s.sketch == nil {
+		s.sketch = NewSketch(sketchAccuracy)
+	}
+	s.sketch.Add(v)
+}
+
+// Merge folds o into s
+func (s *Stats) Merge(o *Stats) {
+	if o == nil || o.Count == 0 {
+		return
+	}
+	if s.Count == 0 {
+		*s = o.Clone()
+		return
+	}
+
+	n := float64(s.Count + o.Count)
+	delta := o.mean - s.mean
+	s.m2 += o.m2 + delta*delta*float64(s.Count)*float64(o.Count)/n
+	s.mean += delta * float64(o.Count) / n
+
+	s.Count += o.Count
+	s.Sum += o.Sum
+	s.Min = math.Min(s.Min, o.Min)
+	s.Max = math.Max(s.Max, o.Max)
+	s.sketch.Merge(o.sketch)
+}
+
+// Clone returns an independent copy
+func (s *Stats) Clone() Stats {
+	cp := *s
+	if s.sketch != nil {
+		cp.sketch = s.sketch.Clone()
+	}
+	return cp
+}
+
+func (s *Stats) Mean() float64 {
+	return s.mean
+}
+
+// Variance is the population variance
+func (s *Stats) Variance() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.m2 / float64(s.Count)
+}
+
+// Percentile estimates the q-th quantile, q in [0, 1], to within 1%
+func (s *Stats) Percentile(q float64) float64 {
+	if s.sketch == nil {
+		return 0
+	}
+	return s.sketch.Quantile(q)
+}
+
+// --------------------
+// Sketch
+// --------------------
+
+// Sketch is a quantile sketch with bounded relative error (DDSketch).
+// Values fall into logarithmic buckets, so memory grows with the range
+// of values rather than their number, and sketches merge exactly.
+type Sketch struct {
+	gamma    float64
+	logGamma float64
+	pos      map[int]int // bucket index -> count, for values > 0
+	neg      map[int]int // same, for |values| of values < 0
+	zeros    int
+	count    int
+}
+
+// NewSketch returns a sketch whose quantiles are within relErr of the
+// true value, e.g. 0.01 for 1%
+func NewSketch(relErr float64) *Sketch {
+	gamma := (1 + relErr) / (1 - relErr)
+	return &Sketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		pos:      make(map[int]int),
+		neg:      make(map[int]int),
+	}
+}
+
+func (s *Sketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value is the estimate for every value in bucket i
+func (s *Sketch) value(i int) float64 {
+	return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+}
+
+func (s *Sketch) Add(v float64) {
+	s.count++
+	switch {
+	case v > 0:
+		s.pos[s.index(v)]++
+	case v < 0:
+		s.neg[s.index(-v)]++
+	default:
+		s.zeros++
+	}
+}
+
+// Merge folds o into s; both must have the same accuracy
+func (s *Sketch) Merge(o *Sketch) {
+	if o == nil {
+		return
+	}
+	for i, n := range o.pos {
+		s.pos[i] += n
+	}
+	for i, n := range o.neg {
+		s.neg[i] += n
+	}
+	s.zeros += o.zeros
+	s.count += o.count
+}
+
+func (s *Sketch) Clone() *Sketch {
+	cp := NewSketch(0)
+	cp.gamma, cp.logGamma = s.gamma, s.logGamma
+	cp.Merge(s)
+	return cp
+}
+
+// Quantile estimates the q-th quantile; q is clamped to [0, 1]
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	if !(q > 0) { // NaN too
+		q = 0
+	}
+	rank := int(min(q, 1) * float64(s.count-1))
+
+	// Walk buckets from the most negative value to the most positive
+	negIdx := sortedKeys(s.neg)
+	for i := len(negIdx) - 1; i >= 0; i-- {
+		if rank -= s.neg[negIdx[i]]; rank < 0 {
+			return -s.value(negIdx[i])
+		}
+	}
+	if rank -= s.zeros; rank < 0 {
+		return 0
+	}
+	posIdx := sortedKeys(s.pos)
+	for _, i := range posIdx {
+		if rank -= s.pos[i]; rank < 0 {
+			return s.value(i)
+		}
+	}
+	if len(posIdx) == 0 {
+		return 0 // unreachable while the bucket counts add up to count
+	}
+	return s.value(posIdx[len(posIdx)-1])
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
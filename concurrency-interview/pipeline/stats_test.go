@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// exact computes mean and population variance the two-pass way
+func exact(values []float64) (mean, variance float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, variance / float64(len(values))
+}
+
+func closeTo(got, want, tol float64) bool {
+	return math.Abs(got-want) <= tol*math.Max(1, math.Abs(want))
+}
+
+func TestStatsMergeMatchesSinglePass(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = 1e6 + rng.NormFloat64()*50 // large offset, small spread
+	}
+	wantMean, wantVar := exact(values)
+
+	for _, parts := range []int{1, 2, 7, 1000} {
+		var merged Stats
+		for p := range parts {
+			var s Stats
+			for _, v := range values[p*len(values)/parts : (p+1)*len(values)/parts] {
+				s.Add(v)
+			}
+			merged.Merge(&s)
+		}
+
+		if merged.Count != len(values) {
+			t.Fatalf("%d parts: count %d", parts, merged.Count)
+		}
+		if !closeTo(merged.Mean(), wantMean, 1e-12) || !closeTo(merged.Variance(), wantVar, 1e-9) {
+			t.Errorf("%d parts: mean %v var %v, want %v %v", parts, merged.Mean(), merged.Variance(), wantMean, wantVar)
+		}
+	}
+
+	var empty Stats
+	empty.Merge(&Stats{})
+	if empty.Count != 0 || empty.Variance() != 0 || empty.Percentile(0.5) != 0 {
+		t.Errorf("merging empty stats = %+v", empty)
+	}
+}
+
+func TestSketchQuantilesWithinRelativeError(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	values := make([]float64, 5000)
+	sketch := NewSketch(sketchAccuracy)
+	for i := range values {
+		// Spans several orders of magnitude, both signs and zero
+		v := math.Exp(rng.Float64()*12) - 1
+		if i%3 == 0 {
+			v = -v
+		}
+		if i%50 == 0 {
+			v = 0
+		}
+		values[i] = v
+		sketch.Add(v)
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0, 0.01, 0.25, 0.5, 0.75, 0.95, 0.99, 1} {
+		want := values[int(q*float64(len(values)-1))]
+		got := sketch.Quantile(q)
+		if math.Abs(got-want) > sketchAccuracy*math.Abs(want)+1e-9 {
+			t.Errorf("q=%v: %v, want %v within %v", q, got, want, sketchAccuracy)
+		}
+	}
+}
+
+func TestSketchQuantileClampsQ(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+	}{
+		{name: "negative only", values: []float64{-3, -2, -1}},
+		{name: "zeros only", values: []float64{0, 0}},
+		{name: "mixed", values: []float64{-1, 0, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSketch(sketchAccuracy)
+			for _, v := range tt.values {
+				s.Add(v)
+			}
+			lo, hi := s.Quantile(0), s.Quantile(1)
+			for _, q := range []float64{-1, 1.5, math.Inf(1), math.NaN()} {
+				got := s.Quantile(q) // must not panic
+				if got != lo && got != hi {
+					t.Errorf("Quantile(%v) = %v, want %v or %v", q, got, lo, hi)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Window says how Aggregate cuts a stream into windows. A window slides
+// forward by its slide; a slide equal to its size makes it tumbling. The
+// zero Window is a tumbling window of one item.
+type Window struct {
+	size, slide   int           // count windows
+	length, every time.Duration // time windows
+}
+
+// CountWindow covers size items and advances every slide items; slide 0
+// means tumbling. size is rounded up to a multiple of slide.
+func CountWindow(size, slide int) Window {
+	size = max(size, 1)
+	if slide <= 0 || slide > size {
+		slide = size
+	}
+	return Window{size: (size + slide - 1) / slide * slide, slide: slide}
+}
+
+// TimeWindow covers length of arrival time and advances every slide;
+// slide 0 means tumbling. length is rounded up to a multiple of slide.
+func TimeWindow(length, slide time.Duration) Window {
+	length = max(length, time.Millisecond)
+	if slide <= 0 || slide > length {
+		slide = length
+	}
+	return Window{length: (length + slide - 1) / slide * slide, every: slide}
+}
+
+// orDefault turns the zero Window into CountWindow(1, 0)
+func (w Window) orDefault() Window {
+	if w.every <= 0 && w.slide <= 0 {
+		return CountWindow(1, 0)
+	}
+	return w
+}
+
+func (w Window) panes() int {
+	if w.every > 0 {
+		return int(w.length / w.every)
+	}
+	return w.size / w.slide
+}
+
+// WindowResult is the per-group statistics of one window
+type WindowResult[K comparable] struct {
+	Start   time.Time
+	End     time.Time
+	Groups  map[K]Stats
+	Partial bool // cut short by the end of the input, or a live snapshot
+}
+
+// Count is the number of items across all groups
+func (r WindowResult[K]) Count() int {
+	n := 0
+	for _, s := range r.Groups {
+		n += s.Count
+	}
+	return n
+}
+
+// pane is one slide's worth of items; windows are merged from panes
+type pane[K comparable] struct {
+	start  time.Time
+	items  int
+	groups map[K]*Stats
+}
+
+func newPane[K comparable](start time.Time) *pane[K] {
+	return &pane[K]{start: start, groups: make(map[K]*Stats)}
+}
+
+// Aggregator holds the state of an Aggregate stage. Its methods are safe
+// to call while the pipeline runs.
+type Aggregator[K comparable] struct {
+	mu      sync.Mutex
+	window  Window
+	closed  []*pane[K] // newest last, at most window.panes()-1 kept
+	current *pane[K]
+	totals  map[K]*Stats
+}
+
+// Snapshot returns the window in progress, open pane included
+func (a *Aggregator[K]) Snapshot() WindowResult[K] {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.resultLocked(time.Now(), true)
+}
+
+// Totals returns per-group statistics over everything seen so far
+func (a *Aggregator[K]) Totals() map[K]Stats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out := make(map[K]Stats, len(a.totals))
+	for k, s := range a.totals {
+		out[k] = s.Clone()
+	}
+	return out
+}
+
+func (a *Aggregator[K]) add(k K, v float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.current.items++
+	for _, groups := range []map[K]*Stats{a.current.groups, a.totals} {
+		s, ok := groups[k]
+		if !ok {
+			s = &Stats{}
+			groups[k] = s
+		}
+		s.Add(v)
+	}
+}
+
+// roll closes the current pane and returns the window it completes, if
+// any. A final roll returns whatever is left as a partial window.
+func (a *Aggregator[K]) roll(now time.Time, final bool) (WindowResult[K], bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	panes := a.window.panes()
+	full := len(a.closed)+1 >= panes
+	if final && a.current.items == 0 {
+		return WindowResult[K]{}, false
+	}
+
+	res := a.resultLocked(now, final)
+	a.closed = append(a.closed, a.current)
+	if len(a.closed) > panes-1 {
+		a.closed = a.closed[len(a.closed)-(panes-1):]
+	}
+	a.current = newPane[K](now)
+
+	if !full && !final {
+		return WindowResult[K]{}, false
+	}
+	return res, res.Count() > 0
+}
+
+func (a *Aggregator[K]) resultLocked(now time.Time, partial bool) WindowResult[K] {
+	res := WindowResult[K]{
+		Start:   a.current.start,
+		End:     now,
+		Groups:  make(map[K]Stats),
+		Partial: partial,
+	}
+	if len(a.closed) > 0 {
+		res.Start = a.closed[0].start
+	}
+	merge := func(p *pane[K]) {
+		for k, s := range p.groups {
+			merged := res.Groups[k]
+			merged.Merge(s)
+			res.Groups[k] = merged
+		}
+	}
+	for _, p := range a.closed {
+		merge(p)
+	}
+	merge(a.current)
+	return res
+}
+
+// Aggregate computes per-group statistics of value(item) over windows
+// of the input, grouped by key; use a constant key for no grouping. Each
+// completed window is emitted, plus a partial one when the input ends.
+// The returned Aggregator serves live partials and running totals.
+func Aggregate[T any, K comparable](p *Pipeline, in <-chan T, w Window, key func(T) K, value func(T) float64) (<-chan WindowResult[K], *Aggregator[K]) {
+	out := make(chan WindowResult[K])
+	w = w.orDefault()
+	agg := &Aggregator[K]{
+		window:  w,
+		current: newPane[K](time.Now()),
+		totals:  make(map[K]*Stats),
+	}
+
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+
+		var tick <-chan time.Time
+		if w.every > 0 {
+			ticker := time.NewTicker(w.every)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case now := <-tick:
+				if res, ok := agg.roll(now, false); ok && !send(ctx, out, res) {
+					return nil
+				}
+			case v, ok := <-in:
+				if !ok {
+					if res, ok := agg.roll(time.Now(), true); ok {
+						send(ctx, out, res)
+					}
+					return nil
+				}
+				agg.add(key(v), value(v))
+
+				if w.slide > 0 && agg.paneItems() == w.slide {
+					if res, ok := agg.roll(time.Now(), false); ok && !send(ctx, out, res) {
+						return nil
+					}
+				}
+			}
+		}
+	})
+	return out, agg
+}
+
+func (a *Aggregator[K]) paneItems() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.current.items
+}
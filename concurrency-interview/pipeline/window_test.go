@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// collectWindows runs items through Aggregate with a constant key and
+// the item as value
+func collectWindows(t *testing.T, w Window, items []int) []WindowResult[string] {
+	t.Helper()
+	p := New(context.Background())
+	windows, _ := Aggregate(p, FromSlice(p, items), w,
+		func(int) string { return "all" },
+		func(v int) float64 { return float64(v) })
+
+	got, err := Reduce(p, windows, []WindowResult[string](nil),
+		func(acc []WindowResult[string], r WindowResult[string]) []WindowResult[string] { return append(acc, r) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestAggregateCountWindows(t *testing.T) {
+	tests := []struct {
+		name        string
+		window      Window
+		items       int
+		wantCounts  []int
+		wantSums    []float64
+		wantPartial []bool
+	}{
+		{
+			name:        "zero value is tumbling of one",
+			window:      Window{},
+			items:       3,
+			wantCounts:  []int{1, 1, 1},
+			wantSums:    []float64{1, 2, 3},
+			wantPartial: []bool{false, false, false},
+		},
+		{
+			name:        "tumbling with a partial tail",
+			window:      CountWindow(3, 0),
+			items:       7,
+			wantCounts:  []int{3, 3, 1},
+			wantSums:    []float64{6, 15, 7},
+			wantPartial: []bool{false, false, true},
+		},
+		{
+			name:        "sliding",
+			window:      CountWindow(4, 2),
+			items:       8,
+			wantCounts:  []int{4, 4, 4},
+			wantSums:    []float64{10, 18, 26}, // 1-4, 3-6, 5-8
+			wantPartial: []bool{false, false, false},
+		},
+		{
+			name:        "sliding ends mid-pane",
+			window:      CountWindow(4, 2),
+			items:       5,
+			wantCounts:  []int{4, 3},
+			wantSums:    []float64{10, 12}, // 1-4, then 3-5
+			wantPartial: []bool{false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := make([]int, tt.items)
+			for i := range items {
+				items[i] = i + 1
+			}
+
+			var counts []int
+			var sums []float64
+			var partial []bool
+			for _, w := range collectWindows(t, tt.window, items) {
+				counts = append(counts, w.Count())
+				sums = append(sums, w.Groups["all"].Sum)
+				partial = append(partial, w.Partial)
+			}
+			if !slices.Equal(counts, tt.wantCounts) || !slices.Equal(sums, tt.wantSums) || !slices.Equal(partial, tt.wantPartial) {
+				t.Errorf("windows counts %v sums %v partial %v, want %v %v %v",
+					counts, sums, partial, tt.wantCounts, tt.wantSums, tt.wantPartial)
+			}
+		})
+	}
+}
+
+func TestAggregateTimeWindows(t *testing.T) {
+	p := New(context.Background())
+
+	// Two bursts several windows apart
+	items := Source(p, func(ctx context.Context, emit func(int) bool) error {
+		for burst := range 2 {
+			if burst > 0 {
+				time.Sleep(150 * time.Millisecond)
+			}
+			for range 5 - burst*2 {
+				if !emit(burst) {
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	windows, agg := Aggregate(p, items, TimeWindow(50*time.Millisecond, 0),
+		func(burst int) int { return burst },
+		func(int) float64 { return 1 })
+
+	got, err := Reduce(p, windows, []WindowResult[int](nil),
+		func(acc []WindowResult[int], r WindowResult[int]) []WindowResult[int] { return append(acc, r) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Empty windows between the bursts are not emitted, and no window
+	// spans both
+	total := 0
+	for _, w := range got {
+		if len(w.Groups) != 1 {
+			t.Errorf("window %v-%v mixes bursts: %v", w.Start, w.End, w.Groups)
+		}
+		total += w.Count()
+	}
+	if total != 8 || len(got) < 2 {
+		t.Errorf("%d windows holding %d items, want at least 2 holding 8", len(got), total)
+	}
+	if totals := agg.Totals(); totals[0].Count != 5 || totals[1].Count != 3 {
+		t.Errorf("totals %v, want 5 and 3", totals)
+	}
+}
+
+func TestAggregateGroupsByKey(t *testing.T) {
+	p := New(context.Background())
+	items := FromSlice(p, []int{1, 2, 3, 4, 5, 6, 7})
+	windows, _ := Aggregate(p, items, CountWindow(7, 0),
+		func(v int) string {
+			if v%2 == 0 {
+				return "even"
+			}
+			return "odd"
+		},
+		func(v int) float64 { return float64(v) })
+
+	w, ok := <-windows
+	if !ok {
+		t.Fatal("no window")
+	}
+	for range windows {
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	odd, even := w.Groups["odd"], w.Groups["even"]
+	if odd.Count != 4 || odd.Sum != 16 || odd.Min != 1 || odd.Max != 7 || odd.Mean() != 4 {
+		t.Errorf("odd group %+v", odd)
+	}
+	if even.Count != 3 || even.Sum != 12 || even.Variance() != 8.0/3 {
+		t.Errorf("even group %+v, variance %v", even, even.Variance())
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// HealthState is a service's health as judged over recent polls
+type HealthState int
+
+const (
+	HealthUnknown HealthState = iota // not polled yet
+	HealthHealthy
+	HealthDegraded
+	HealthDown
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthHealthy:
+		return "healthy"
+	case HealthDegraded:
+		return "degraded"
+	case HealthDown:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// HealthPolicy says how often services are polled and how poll results
+// move them between states. A single bad or good poll never flips a
+// settled state; it takes a run of them.
+type HealthPolicy struct {
+	Interval    time.Duration // between polls of a service that is up; must be positive
+	Jitter      float64       // randomize each interval by up to this fraction, at least 0 and under 1
+	PollTimeout time.Duration // per poll; 0 for none
+	DownBackoff RetryPolicy   // interval growth while down, from BaseDelay up to MaxDelay
+
+	// A successful poll counts as degraded if any of these is exceeded
+	MaxCPU     float64
+	MaxMemory  float64
+	MaxLatency time.Duration
+
+	FailuresToDown  int // consecutive failed polls
+	ChecksToDegrade int // consecutive degraded or failed polls
+	ChecksToRecover int // consecutive good polls to leave degraded, successful ones to leave down
+}
+
+// DefaultHealthPolicy polls every interval, marks a service down after 3
+// failures and degraded after 2 bad polls, and wants 2 good ones back
+func DefaultHealthPolicy(interval time.Duration) HealthPolicy {
+	return HealthPolicy{
+		Interval:    interval,
+		Jitter:      0.2,
+		PollTimeout: time.Second,
+		DownBackoff: RetryPolicy{
+			BaseDelay:  2 * interval,
+			MaxDelay:   16 * interval,
+			Multiplier: 2,
+			Jitter:     0.2,
+		},
+		MaxCPU:          0.9,
+		MaxMemory:       0.75,
+		MaxLatency:      500 * time.Millisecond,
+		FailuresToDown:  3,
+		ChecksToDegrade: 2,
+		ChecksToRecover: 2,
+	}
+}
+
+// validate rejects policies that would poll in a tight loop
+func (p *HealthPolicy) validate() error {
+	if p.Interval <= 0 {
+		return fmt.Errorf("poll interval must be positive, got %v", p.Interval)
+	}
+	if p.Jitter < 0 || p.Jitter >= 1 {
+		return fmt.Errorf("poll jitter must be in [0, 1), got %v", p.Jitter)
+	}
+	return nil
+}
+
+// HealthEvent reports a service changing state
+type HealthEvent struct {
+	ServiceID string
+	From      HealthState
+	To        HealthState
+	At        time.Time
+	Metrics   *ServiceMetrics // from the poll that decided it, nil if it failed
+	Err       error
+}
+
+func (e HealthEvent) String() string {
+	s := fmt.Sprintf("%s: %v -> %v", e.ServiceID, e.From, e.To)
+	if e.Err != nil {
+		s += fmt.Sprintf(" (%v)", e.Err)
+	}
+	return s
+}
+
+// ServiceStatus is the current view of one service
+type ServiceStatus struct {
+	State    HealthState
+	Since    time.Time
+	LastPoll time.Time
+	Metrics  *ServiceMetrics // last successful poll
+	Err      error           // last poll's error, if it failed
+	NextPoll time.Time
+}
+
+// --------------------
+// State machine
+// --------------------
+
+type pollOutcome int
+
+const (
+	pollGood pollOutcome = iota
+	pollDegraded
+	pollFailed
+)
+
+// serviceHealth runs one service's state machine. Guarded by
+// ServicePoller.mu.
+type serviceHealth struct {
+	status ServiceStatus
+
+	failed int // consecutive failed polls
+	bad    int // consecutive degraded or failed polls
+	good   int // consecutive good polls
+	up     int // consecutive successful polls
+	downs  int // polls made while down, for backoff
+}
+
+func (p *HealthPolicy) classify(m *ServiceMetrics, latency time.Duration, err error) pollOutcome {
+	switch {
+	case err != nil:
+		return pollFailed
+	case p.MaxCPU > 0 && m.CPUUsage > p.MaxCPU,
+		p.MaxMemory > 0 && m.MemoryUsage > p.MaxMemory,
+		p.MaxLatency > 0 && latency > p.MaxLatency:
+		return pollDegraded
+	default:
+		return pollGood
+	}
+}
+
+// observe folds in one poll and returns the new state
+func (h *serviceHealth) observe(p *HealthPolicy, out pollOutcome) HealthState {
+	if out == pollFailed {
+		h.failed++
+		h.up = 0
+	} else {
+		h.failed = 0
+		h.up++
+	}
+	if out == pollGood {
+		h.good++
+		h.bad = 0
+	} else {
+		h.bad++
+		h.good = 0
+	}
+
+	state := h.status.State
+	switch {
+	case state == HealthUnknown:
+		// Nothing to be stable against yet: trust the first poll
+		state = map[pollOutcome]HealthState{
+			pollGood:     HealthHealthy,
+			pollDegraded: HealthDegraded,
+			pollFailed:   HealthDown,
+		}[out]
+	case state == HealthDown:
+		if h.up >= max(p.ChecksToRecover, 1) {
+			state = HealthHealthy
+			if out == pollDegraded {
+				state = HealthDegraded
+			}
+		}
+	case h.failed >= max(p.FailuresToDown, 1):
+		state = HealthDown
+	case state == HealthHealthy && h.bad >= max(p.ChecksToDegrade, 1):
+		state = HealthDegraded
+	case state == HealthDegraded && h.good >= max(p.ChecksToRecover, 1):
+		state = HealthHealthy
+	}
+
+	if state == HealthDown {
+		h.downs++
+	} else {
+		h.downs = 0
+	}
+	return state
+}
+
+// nextDelay is the wait before the next poll, backed off while down
+func (h *serviceHealth) nextDelay(p *HealthPolicy) time.Duration {
+	if h.status.State == HealthDown && p.DownBackoff.BaseDelay > 0 {
+		return p.DownBackoff.Backoff(h.downs)
+	}
+	return jittered(p.Interval, p.Jitter)
+}
+
+func jittered(d time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return d
+	}
+	return d + time.Duration(float64(d)*jitter*(2*rand.Float64()-1))
+}
+
+// --------------------
+// ServicePoller
+// --------------------
+
+// ServicePoller polls each service on its own schedule and tracks its
+// health, emitting an event on every state change
+type ServicePoller struct {
+	fetch   func(context.Context, string) (*ServiceMetrics, error)
+	policy  HealthPolicy
+	limiter *rate.Limiter
+
+	mu       sync.Mutex
+	services map[string]*serviceHealth
+	subs     map[chan HealthEvent]struct{}
+	stopped  bool
+	dropped  atomic.Int64
+}
+
+// NewServicePoller polls with fetch, making at most maxRPS calls per
+// second across all services. It fails if the policy's interval or jitter
+// is out of range.
+func NewServicePoller(fetch func(context.Context, string) (*ServiceMetrics, error), policy HealthPolicy, maxRPS int) (*ServicePoller, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return &ServicePoller{
+		fetch:    fetch,
+		policy:   policy,
+		limiter:  rate.NewLimiter(rate.Limit(max(maxRPS, 1)), max(maxRPS, 1)),
+		services: make(map[string]*serviceHealth),
+		subs:     make(map[chan HealthEvent]struct{}),
+	}, nil
+}
+
+// Subscribe returns a channel of state changes from now on and a func to
+// stop receiving them. Polling never waits on a subscriber: events that
+// don't fit in buffer are dropped and counted, and Status still has the
+// truth. Run closes every subscription when it returns.
+func (p *ServicePoller) Subscribe(buffer int) (<-chan HealthEvent, func()) {
+	ch := make(chan HealthEvent, buffer)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		close(ch)
+		return ch, func() {}
+	}
+	p.subs[ch] = struct{}{}
+
+	return ch, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.subs[ch]; ok {
+			delete(p.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Dropped counts events lost to full subscriber buffers
+func (p *ServicePoller) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+// Status returns the current view of every service
+func (p *ServicePoller) Status() map[string]ServiceStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make(map[string]ServiceStatus, len(p.services))
+	for id, h := range p.services {
+		out[id] = h.status
+	}
+	return out
+}
+
+// Run polls serviceIDs until ctx is done, then closes every subscription.
+// First polls are spread over one interval so the services aren't all
+// hit at once.
+func (p *ServicePoller) Run(ctx context.Context, serviceIDs []string) {
+	var wg sync.WaitGroup
+
+	p.mu.Lock()
+	for _, id := range serviceIDs {
+		if _, ok := p.services[id]; ok {
+			continue
+		}
+		p.services[id] = &serviceHealth{}
+
+		wg.Add(1)
+		go func(id string, offset time.Duration) {
+			defer wg.Done()
+			p.pollLoop(ctx, id, offset)
+		}(id, time.Duration(rand.Int63n(int64(p.policy.Interval))))
+	}
+	p.mu.Unlock()
+
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	for ch := range p.subs {
+		delete(p.subs, ch)
+		close(ch)
+	}
+}
+
+func (p *ServicePoller) pollLoop(ctx context.Context, id string, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if err := p.limiter.Wait(ctx); err != nil {
+			return
+		}
+		metrics, latency, err := p.poll(ctx, id)
+		if ctx.Err() != nil {
+			return
+		}
+		timer.Reset(p.record(id, metrics, latency, err))
+	}
+}
+
+func (p *ServicePoller) poll(ctx context.Context, id string) (*ServiceMetrics, time.Duration, error) {
+	if p.policy.PollTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.policy.PollTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	m, err := p.fetch(ctx, id)
+	return m, time.Since(start), err
+}
+
+// record applies a poll to id's state machine, publishes any state change
+// and returns the delay until the next poll
+func (p *ServicePoller) record(id string, m *ServiceMetrics, latency time.Duration, err error) time.Duration {
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h := p.services[id]
+	from := h.status.State
+	to := h.observe(&p.policy, p.policy.classify(m, latency, err))
+
+	h.status.LastPoll = now
+	h.status.Err = err
+	if err == nil {
+		h.status.Metrics = m
+	}
+	if to != from {
+		h.status.State = to
+		h.status.Since = now
+		p.publishLocked(HealthEvent{ServiceID: id, From: from, To: to, At: now, Metrics: m, Err: err})
+	}
+
+	delay := h.nextDelay(&p.policy)
+	h.status.NextPoll = now.Add(delay)
+	return delay
+}
+
+func (p *ServicePoller) publishLocked(ev HealthEvent) {
+	for ch := range p.subs {
+		select {
+		case ch <- ev:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+}
+
+// ServicePollerDemo watches 20 services for a while. service-7 goes dark
+// for a few seconds to show it going down, backing off and recovering.
+func ServicePollerDemo() {
+	serviceIDs := make([]string, 20)
+	for i := range serviceIDs {
+		serviceIDs[i] = fmt.Sprintf("service-%d", i+1)
+	}
+
+	start := time.Now()
+	fetch := func(ctx context.Context, id string) (*ServiceMetrics, error) {
+		if since := time.Since(start); id == "service-7" && since > 2*time.Second && since < 6*time.Second {
+			return nil, fmt.Errorf("service %s unreachable", id)
+		}
+		return fetchServiceMetricsCtx(ctx, id)
+	}
+
+	policy := DefaultHealthPolicy(500 * time.Millisecond)
+	poller, err := NewServicePoller(fetch, policy, 40)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	events, unsubscribe := poller.Subscribe(64)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	defer cancel()
+	go poller.Run(ctx, serviceIDs)
+
+	for ev := range events {
+		fmt.Printf("[%5v] %v\n", ev.At.Sub(start).Round(100*time.Millisecond), ev)
+	}
+
+	counts := make(map[HealthState]int)
+	for _, st := range poller.Status() {
+		counts[st.State]++
+	}
+	fmt.Printf("Final: %d healthy, %d degraded, %d down; %d events dropped\n",
+		counts[HealthHealthy], counts[HealthDegraded], counts[HealthDown], poller.Dropped())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testHealthPolicy() HealthPolicy {
+	p := DefaultHealthPolicy(100 * time.Millisecond)
+	p.Jitter = 0
+	p.DownBackoff.Jitter = 0
+	return p
+}
+
+func TestServiceHealthObserve(t *testing.T) {
+	const (
+		G = pollGood
+		D = pollDegraded
+		F = pollFailed
+	)
+	const (
+		up   = HealthHealthy
+		deg  = HealthDegraded
+		down = HealthDown
+	)
+
+	tests := []struct {
+		name  string
+		polls []pollOutcome
+		want  []HealthState // state after each poll
+	}{
+		{name: "first poll decides", polls: []pollOutcome{D}, want: []HealthState{deg}},
+		{name: "healthy to degraded after ChecksToDegrade", polls: []pollOutcome{G, D, D}, want: []HealthState{up, up, deg}},
+		{name: "failures count as bad checks", polls: []pollOutcome{G, D, F}, want: []HealthState{up, up, deg}},
+		{name: "one bad poll does not flip healthy", polls: []pollOutcome{G, D, G, F, G}, want: []HealthState{up, up, up, up, up}},
+		{name: "degraded to down after FailuresToDown", polls: []pollOutcome{D, F, F, F}, want: []HealthState{deg, deg, deg, down}},
+		{name: "a success resets the failure run", polls: []pollOutcome{D, F, F, D, F, F}, want: []HealthState{deg, deg, deg, deg, deg, deg}},
+		{name: "degraded recovers after ChecksToRecover", polls: []pollOutcome{D, G, D, G, G}, want: []HealthState{deg, deg, deg, deg, up}},
+		{name: "one good poll does not leave down", polls: []pollOutcome{F, G, F, G}, want: []HealthState{down, down, down, down}},
+		{name: "down to healthy after ChecksToRecover", polls: []pollOutcome{F, G, G}, want: []HealthState{down, down, up}},
+		{name: "down recovers into degraded", polls: []pollOutcome{F, G, D}, want: []HealthState{down, down, deg}},
+	}
+
+	policy := testHealthPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h serviceHealth
+			var got []HealthState
+			for _, out := range tt.polls {
+				h.status.State = h.observe(&policy, out)
+				got = append(got, h.status.State)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("states %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceHealthBacksOffWhileDown(t *testing.T) {
+	policy := testHealthPolicy()
+	var h serviceHealth
+
+	var delays []time.Duration
+	for range 8 {
+		h.status.State = h.observe(&policy, pollFailed)
+		if h.status.State == HealthDown {
+			delays = append(delays, h.nextDelay(&policy))
+		}
+	}
+
+	if len(delays) == 0 || delays[0] != policy.DownBackoff.BaseDelay {
+		t.Fatalf("delays %v, want to start at %v", delays, policy.DownBackoff.BaseDelay)
+	}
+	for i := 1; i < len(delays); i++ {
+		if delays[i] < delays[i-1] {
+			t.Errorf("delays %v shrink while down", delays)
+		}
+	}
+	if last := delays[len(delays)-1]; last != policy.DownBackoff.MaxDelay {
+		t.Errorf("delays %v, want capped at %v", delays, policy.DownBackoff.MaxDelay)
+	}
+
+	// Back up, polls return to the normal interval
+	for range policy.ChecksToRecover {
+		h.status.State = h.observe(&policy, pollGood)
+	}
+	if h.status.State != HealthHealthy || h.nextDelay(&policy) != policy.Interval {
+		t.Errorf("after recovery: %v, next poll in %v", h.status.State, h.nextDelay(&policy))
+	}
+}
+
+func TestServicePollerRejectsBadInterval(t *testing.T) {
+	fetch := func(context.Context, string) (*ServiceMetrics, error) { return &ServiceMetrics{}, nil }
+
+	for _, mod := range []func(*HealthPolicy){
+		func(p *HealthPolicy) { p.Interval = 0 },
+		func(p *HealthPolicy) { p.Interval = -time.Second },
+		func(p *HealthPolicy) { p.Jitter = 1 },
+		func(p *HealthPolicy) { p.Jitter = -0.1 },
+	} {
+		policy := testHealthPolicy()
+		mod(&policy)
+		if _, err := NewServicePoller(fetch, policy, 10); err == nil {
+			t.Errorf("NewServicePoller accepted interval %v jitter %v", policy.Interval, policy.Jitter)
+		}
+	}
+}
+
+func TestServicePollerRunClosesSubscriptions(t *testing.T) {
+	fetch := func(context.Context, string) (*ServiceMetrics, error) { return &ServiceMetrics{}, nil }
+	poller, err := NewServicePoller(fetch, testHealthPolicy(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := poller.Subscribe(8)
+	defer unsubscribe() // after Run closed it: must not double-close
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		poller.Run(ctx, []string{"a", "b"})
+	}()
+
+	var got []HealthEvent
+	timeout := time.After(2 * time.Second)
+	for open := true; open; {
+		select {
+		case ev, ok := <-events:
+			if ok {
+				got = append(got, ev)
+			}
+			open = ok
+		case <-timeout:
+			t.Fatal("subscription not closed after Run returned")
+		}
+	}
+	<-done
+
+	if len(got) != 2 {
+		t.Errorf("events %v, want each service going healthy", got)
+	}
+	for id, st := range poller.Status() {
+		if st.State != HealthHealthy {
+			t.Errorf("%s is %v", id, st.State)
+		}
+	}
+
+	// Subscribing once the poller has stopped gets a closed channel
+	late, _ := poller.Subscribe(1)
+	if _, ok := <-late; ok {
+		t.Error("late subscription received an event")
+	}
+}
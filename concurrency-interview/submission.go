@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Submission errors shared by Dispatcher and TaskProcessor
+var (
+	ErrQueueFull = errors.New("queue full")
+	ErrClosed    = errors.New("not accepting submissions, shutting down")
+)
+
+// QueueStats is a snapshot of a work queue, for producers that want to
+// slow down or shed load before submissions start failing
+type QueueStats struct {
+	Depth     int   // items waiting for a worker
+	Capacity  int   // queue buffer size
+	Submitted int64 // accepted onto the queue
+	Rejected  int64 // refused: queue full, caller's ctx done, or shutting down
+}
+
+// submitBatch calls submit for items 0..n-1 in order and returns one
+// error per item, nil for those accepted. Once ctx is done the rest fail
+// with ctx.Err() without being attempted.
+func submitBatch(ctx context.Context, n int, submit func(ctx context.Context, i int) error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = submit(ctx, i)
+	}
+	return errs
+}
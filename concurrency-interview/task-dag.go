@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TaskState is where a task is in the dependency graph
+type TaskState string
+
+const (
+	TaskBlocked   TaskState = "blocked" // waiting on dependencies
+	TaskQueued    TaskState = "queued"  // queued, running or waiting to retry
+	TaskSucceeded TaskState = "succeeded"
+	TaskFailed    TaskState = "failed"
+	TaskCancelled TaskState = "cancelled"
+)
+
+var (
+	ErrDependencyFailed = errors.New("dependency did not succeed")
+	ErrDuplicateTask    = errors.New("task ID already submitted")
+)
+
+// defaultDAGRetention is how long finished tasks stay in the graph
+const defaultDAGRetention = 10 * time.Minute
+
+// CycleError is returned by Submit when a task's dependencies lead back
+// to itself
+type CycleError struct {
+	Path []string
+}
+
+func (e *CycleError) Error() string {
+	return "dependency cycle: " + strings.Join(e.Path, " -> ")
+}
+
+// DAGStatus counts tasks by state across the tasks still tracked
+type DAGStatus struct {
+	Blocked   int
+	Queued    int
+	Succeeded int
+	Failed    int
+	Cancelled int
+}
+
+// Done reports whether every submitted task has finished
+func (s DAGStatus) Done() bool {
+	return s.Blocked == 0 && s.Queued == 0
+}
+
+// OK reports whether every submitted task has finished successfully
+func (s DAGStatus) OK() bool {
+	return s.Done() && s.Failed == 0 && s.Cancelled == 0
+}
+
+func (s DAGStatus) String() string {
+	return fmt.Sprintf("blocked=%d queued=%d succeeded=%d failed=%d cancelled=%d",
+		s.Blocked, s.Queued, s.Succeeded, s.Failed, s.Cancelled)
+}
+
+type dagNode struct {
+	task       Task
+	state      TaskState
+	waiting    int       // dependencies not yet succeeded
+	dependents []string  // tasks blocked on this one
+	settled    time.Time // when it reached a final state
+}
+
+func (n *dagNode) terminal() bool {
+	return n.state == TaskSucceeded || n.state == TaskFailed || n.state == TaskCancelled
+}
+
+// taskGraph tracks submitted tasks by ID so later tasks can depend on
+// them. Dependencies may name tasks not yet submitted; the dependent
+// stays blocked until they arrive and succeed. Finished tasks are kept
+// for the retention window, after which their IDs may be reused and a
+// task depending on one waits for it to be submitted again.
+type taskGraph struct {
+	mu    sync.Mutex
+	nodes map[string]*dagNode
+	// waitingOn lists dependents of IDs that haven't been submitted yet
+	waitingOn map[string][]string
+	retention time.Duration
+	lastPrune time.Time
+}
+
+func newTaskGraph() *taskGraph {
+	return &taskGraph{
+		nodes:     make(map[string]*dagNode),
+		waitingOn: make(map[string][]string),
+		retention: defaultDAGRetention,
+		lastPrune: time.Now(),
+	}
+}
+
+func (g *taskGraph) setRetention(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.retention = d
+}
+
+// pruneLocked forgets tasks that finished more than the retention window
+// ago. It runs at most once per half window.
+func (g *taskGraph) pruneLocked(now time.Time) {
+	if now.Sub(g.lastPrune) < g.retention/2 {
+		return
+	}
+	g.lastPrune = now
+
+	for id, node := range g.nodes {
+		if node.terminal() && now.Sub(node.settled) > g.retention {
+			delete(g.nodes, id)
+		}
+	}
+}
+
+// add registers task and reports whether it can run now. It fails on a
+// duplicate ID, a cycle, or a dependency that has already failed.
+func (g *taskGraph) add(task Task) (ready bool, err error) {
+	if task.ID == "" {
+		// Nothing can depend on an anonymous task, so don't track it
+		if len(task.DependsOn) > 0 {
+			return false, errors.New("a task with dependencies needs an ID")
+		}
+		return true, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pruneLocked(time.Now())
+	if _, ok := g.nodes[task.ID]; ok {
+		return false, fmt.Errorf("%w: %s", ErrDuplicateTask, task.ID)
+	}
+	if path := g.findCycleLocked(task); path != nil {
+		return false, &CycleError{Path: path}
+	}
+
+	node := &dagNode{task: task, state: TaskQueued}
+	for _, dep := range task.DependsOn {
+		depNode, ok := g.nodes[dep]
+		switch {
+		case !ok:
+			node.waiting++
+		case depNode.state == TaskSucceeded:
+			continue
+		case depNode.state == TaskFailed || depNode.state == TaskCancelled:
+			return false, fmt.Errorf("%w: %s", ErrDependencyFailed, dep)
+		default:
+			node.waiting++
+		}
+	}
+
+	// Only now that the task is accepted, link it to its dependencies
+	for _, dep := range task.DependsOn {
+		if depNode, ok := g.nodes[dep]; ok {
+			if depNode.state != TaskSucceeded {
+				depNode.dependents = append(depNode.dependents, task.ID)
+			}
+		} else {
+			g.waitingOn[dep] = append(g.waitingOn[dep], task.ID)
+		}
+	}
+	node.dependents = g.waitingOn[task.ID]
+	delete(g.waitingOn, task.ID)
+
+	if node.waiting > 0 {
+		node.state = TaskBlocked
+	}
+	g.nodes[task.ID] = node
+	return node.waiting == 0, nil
+}
+
+// findCycleLocked walks task's dependencies depth-first looking for a
+// path back to task.ID
+func (g *taskGraph) findCycleLocked(task Task) []string {
+	visited := make(map[string]bool)
+	var walk func(id string, path []string) []string
+	walk = func(id string, path []string) []string {
+		path = append(path, id)
+		if id == task.ID {
+			return path
+		}
+		if visited[id] {
+			return nil
+		}
+		visited[id] = true
+		if node, ok := g.nodes[id]; ok {
+			for _, dep := range node.task.DependsOn {
+				if cycle := walk(dep, path); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, dep := range task.DependsOn {
+		if cycle := walk(dep, []string{task.ID}); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// remove forgets a task that was registered but never queued
+func (g *taskGraph) remove(task Task) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	node, ok := g.nodes[task.ID]
+	if !ok {
+		return
+	}
+	delete(g.nodes, task.ID)
+	if len(node.dependents) > 0 {
+		g.waitingOn[task.ID] = node.dependents
+	}
+	for _, dep := range task.DependsOn {
+		if deps, ok := g.waitingOn[dep]; ok {
+			g.waitingOn[dep] = without(deps, task.ID)
+			if len(g.waitingOn[dep]) == 0 {
+				delete(g.waitingOn, dep)
+			}
+		} else if depNode, ok := g.nodes[dep]; ok {
+			depNode.dependents = without(depNode.dependents, task.ID)
+		}
+	}
+}
+
+func without(ids []string, id string) []string {
+	out := ids[:0]
+	for _, v := range ids {
+		if v != id {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// settle records a task's final result. It returns dependents that are
+// now ready to run and, if the task didn't succeed, blocked dependents
+// that must be cancelled. Settling twice is a no-op.
+func (g *taskGraph) settle(id string, res Result) (ready, doomed []Task) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	node, ok := g.nodes[id]
+	if !ok || node.terminal() {
+		return nil, nil
+	}
+	node.state = stateOf(res)
+	node.settled = time.Now()
+
+	for _, depID := range node.dependents {
+		dependent, ok := g.nodes[depID]
+		if !ok || dependent.state != TaskBlocked {
+			continue
+		}
+		if node.state != TaskSucceeded {
+			doomed = append(doomed, dependent.task)
+			continue
+		}
+		dependent.waiting--
+		if dependent.waiting == 0 {
+			dependent.state = TaskQueued
+			ready = append(ready, dependent.task)
+		}
+	}
+	node.dependents = nil
+	return ready, doomed
+}
+
+// blocked returns every task still waiting on dependencies
+func (g *taskGraph) blocked() []Task {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var out []Task
+	for _, node := range g.nodes {
+		if node.state == TaskBlocked {
+			out = append(out, node.task)
+		}
+	}
+	return out
+}
+
+func (g *taskGraph) state(id string) (TaskState, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	node, ok := g.nodes[id]
+	if !ok {
+		return "", false
+	}
+	return node.state, true
+}
+
+func (g *taskGraph) status() DAGStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var s DAGStatus
+	for _, node := range g.nodes {
+		switch node.state {
+		case TaskBlocked:
+			s.Blocked++
+		case TaskQueued:
+			s.Queued++
+		case TaskSucceeded:
+			s.Succeeded++
+		case TaskFailed:
+			s.Failed++
+		case TaskCancelled:
+			s.Cancelled++
+		}
+	}
+	return s
+}
+
+func stateOf(res Result) TaskState {
+	switch {
+	case res.Success:
+		return TaskSucceeded
+	case errors.Is(res.Error, context.Canceled),
+		errors.Is(res.Error, ErrDependencyFailed),
+		errors.Is(res.Error, ErrClosed):
+		return TaskCancelled
+	default:
+		return TaskFailed
+	}
+}
+
+// --------------------
+// TaskProcessor integration
+// --------------------
+
+// TaskState reports where a submitted task is
+func (tp *TaskProcessor) TaskState(id string) (TaskState, bool) {
+	return tp.dag.state(id)
+}
+
+// DAGStatus counts submitted tasks by state
+func (tp *TaskProcessor) DAGStatus() DAGStatus {
+	return tp.dag.status()
+}
+
+// SetDAGRetention changes how long finished tasks stay visible to
+// TaskState and dependents, and keep their IDs reserved (default 10
+// minutes)
+func (tp *TaskProcessor) SetDAGRetention(d time.Duration) {
+	tp.dag.setRetention(d)
+}
+
+// settle updates the graph once task's handle has resolved, queueing
+// dependents that are now ready and cancelling those that never will be
+func (tp *TaskProcessor) settle(task Task) {
+	ready, doomed := tp.dag.settle(task.ID, task.handle.result)
+
+	// Through the retry scheduler, so a worker never blocks on a full queue
+	for _, t := range ready {
+		tp.retries.schedule(t, time.Now())
+	}
+	for _, t := range doomed {
+		err := fmt.Errorf("%w: %s", ErrDependencyFailed, task.ID)
+		if tp.finish(t, Result{TaskID: t.ID, Error: err}) {
+			tp.metrics.IncrementFailed()
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCancelBlockedTaskSettlesDependents(t *testing.T) {
+	// Not started: nothing runs, so a and b never finish on their own
+	tp := NewTaskProcessor(1)
+	ctx := context.Background()
+
+	if _, err := tp.Submit(ctx, Task{ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := tp.Submit(ctx, Task{ID: "b", DependsOn: []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := tp.Submit(ctx, Task{ID: "c", DependsOn: []string{"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Cancel()
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	res, err := c.Wait(waitCtx)
+	if err != nil {
+		t.Fatalf("dependent of a cancelled blocked task never resolved: %v", err)
+	}
+	if !errors.Is(res.Error, ErrDependencyFailed) {
+		t.Errorf("c finished with %v, want ErrDependencyFailed", res.Error)
+	}
+	for id, want := range map[string]TaskState{"a": TaskQueued, "b": TaskCancelled, "c": TaskCancelled} {
+		if got, _ := tp.TaskState(id); got != want {
+			t.Errorf("TaskState(%s) = %s, want %s", id, got, want)
+		}
+	}
+}
+
+func TestTaskGraphPrunesFinishedTasks(t *testing.T) {
+	g := newTaskGraph()
+	g.setRetention(20 * time.Millisecond)
+
+	if _, err := g.add(Task{ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.add(Task{ID: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	g.settle("a", Result{TaskID: "a", Success: true})
+
+	// Within the window the ID is still taken
+	if _, err := g.add(Task{ID: "a"}); !errors.Is(err, ErrDuplicateTask) {
+		t.Fatalf("re-adding a finished task = %v, want ErrDuplicateTask", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := g.add(Task{ID: "a"}); err != nil {
+		t.Fatalf("re-adding a after retention = %v", err)
+	}
+	if st := g.status(); st.Queued != 2 || st.Succeeded != 0 {
+		t.Errorf("status %v, want only the two unfinished tasks", st)
+	}
+	if got, _ := g.state("b"); got != TaskQueued {
+		t.Errorf("unfinished task b pruned: state %q", got)
+	}
+}
+
+func TestDependentsRunWithoutRetries(t *testing.T) {
+	tp := NewTaskProcessor(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tp.StartWoRetry(ctx)
+	defer tp.Stop()
+
+	// processTask fails at random, so use several pairs; each dependent
+	// must resolve either way, and run whenever its dependency succeeded
+	for i := range 5 {
+		parent := fmt.Sprintf("parent-%d", i)
+		a, err := tp.Submit(ctx, Task{ID: parent})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := tp.Submit(ctx, Task{ID: fmt.Sprintf("child-%d", i), DependsOn: []string{parent}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resA, err := a.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resB, err := b.Wait(ctx)
+		if err != nil {
+			t.Fatalf("dependent of %s never resolved: %v", parent, err)
+		}
+		if resA.Success && errors.Is(resB.Error, ErrDependencyFailed) {
+			t.Errorf("child-%d failed its dependency although %s succeeded", i, parent)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// TaskHandle is returned by Submit and resolves once with the task's
+// final result
+type TaskHandle struct {
+	ID string
+
+	ctx    context.Context // cancelled by Cancel or on completion
+	cancel context.CancelFunc
+	once   sync.Once
+	done   chan struct{}
+	result Result
+
+	onCancel func() // settles the task's dependents; set by Submit
+}
+
+func newTaskHandle(id string) *TaskHandle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TaskHandle{ID: id, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// Wait blocks until the task finishes or ctx is done
+func (h *TaskHandle) Wait(ctx context.Context) (Result, error) {
+	select {
+	case <-h.done:
+		return h.result, nil
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+}
+
+// Done is closed once the result is available
+func (h *TaskHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Cancel abandons the task. A queued task is skipped, a running one has
+// its context cancelled, and Wait returns a context.Canceled result at
+// once. Tasks depending on it are cancelled straight away, even while it
+// is still blocked. Cancelling a finished task does nothing.
+func (h *TaskHandle) Cancel() {
+	if h.complete(Result{TaskID: h.ID, Error: context.Canceled}) && h.onCancel != nil {
+		h.onCancel()
+	}
+}
+
+func (h *TaskHandle) cancelled() bool {
+	return h.ctx.Err() != nil
+}
+
+// complete resolves the handle with res. Only the first call wins; it
+// reports whether this one did.
+func (h *TaskHandle) complete(res Result) bool {
+	won := false
+	h.once.Do(func() {
+		h.result = res
+		close(h.done)
+		won = true
+	})
+	h.cancel()
+	return won
+}
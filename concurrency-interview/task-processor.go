@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,7 +12,14 @@ import (
 type Task struct {
 	ID      string
 	Payload string
-	Retries int
+	Retries int          // retries so far
+	Retry   *RetryPolicy // overrides the processor's policy when set
+
+	// DependsOn lists task IDs that must succeed before this one runs.
+	// They may be submitted later; the task waits until they are.
+	DependsOn []string
+
+	handle *TaskHandle // set by Submit
 }
 
 // Result represents the outcome of task processing
@@ -27,37 +35,146 @@ type Result struct {
 
 type TaskProcessor struct {
 	WorkerCount int
+	Retry       RetryPolicy // default for tasks without their own
 	Tasks       chan Task
-	Results     chan Result
 	done        chan struct{}
 	metrics     *Metrics
+	retries     *delayQueue[Task]
+	dag         *taskGraph
 	wg          sync.WaitGroup
+
+	results    chan Result // optional subscription, see Subscribe
+	subscribed atomic.Bool
+
+	// sendMu keeps Stop from closing Tasks while a Submit is sending on it
+	sendMu    sync.RWMutex
+	submitted atomic.Int64
+	rejected  atomic.Int64
 }
 
 // NewTaskProcessor initializes the processor with given worker count
 func NewTaskProcessor(workerCount int) *TaskProcessor {
 	return &TaskProcessor{
 		WorkerCount: workerCount,
+		Retry:       DefaultRetryPolicy(),
 		Tasks:       make(chan Task, 100),
-		Results:     make(chan Result, 100),
 		done:        make(chan struct{}),
 		metrics:     &Metrics{},
+		retries:     newDelayQueue[Task](),
+		dag:         newTaskGraph(),
+		results:     make(chan Result, 100),
 	}
 }
 
-// Submit adds a task to the queue
-func (tp *TaskProcessor) Submit(task Task) error {
+// Submit queues task, waiting for space until ctx is done or the
+// processor stops. The handle resolves with the task's final result.
+// A task with dependencies is held until they succeed, and cancelled if
+// any of them fails. Submit rejects duplicate IDs, dependency cycles
+// (*CycleError) and dependencies that have already failed.
+func (tp *TaskProcessor) Submit(ctx context.Context, task Task) (*TaskHandle, error) {
+	return tp.submit(ctx, task, true)
+}
+
+// TrySubmit queues task only if there is space right now, otherwise it
+// returns ErrQueueFull
+func (tp *TaskProcessor) TrySubmit(task Task) (*TaskHandle, error) {
+	return tp.submit(context.Background(), task, false)
+}
+
+// SubmitBatch submits tasks in order, waiting for space like Submit, and
+// returns a handle and an error per task; the handle is nil on error
+func (tp *TaskProcessor) SubmitBatch(ctx context.Context, tasks []Task) ([]*TaskHandle, []error) {
+	handles := make([]*TaskHandle, len(tasks))
+	errs := submitBatch(ctx, len(tasks), func(ctx context.Context, i int) error {
+		var err error
+		handles[i], err = tp.Submit(ctx, tasks[i])
+		return err
+	})
+	return handles, errs
+}
+
+// Subscribe returns a channel that receives every task result from now
+// on, in addition to the per-task handles. Stop closes it. The channel is
+// shared, and workers wait for it to be read, so keep draining it.
+// Cancelled tasks are reported only through their handles.
+func (tp *TaskProcessor) Subscribe() <-chan Result {
+	tp.subscribed.Store(true)
+	return tp.results
+}
+
+// Stats reports queue depth and submission counters
+func (tp *TaskProcessor) Stats() QueueStats {
+	return QueueStats{
+		Depth:     len(tp.Tasks),
+		Capacity:  cap(tp.Tasks),
+		Submitted: tp.submitted.Load(),
+		Rejected:  tp.rejected.Load(),
+	}
+}
+
+func (tp *TaskProcessor) submit(ctx context.Context, task Task, wait bool) (*TaskHandle, error) {
+	tp.sendMu.RLock()
+	defer tp.sendMu.RUnlock()
+
 	select {
 	case <-tp.done:
-		return fmt.Errorf("cannot submit, processor stopped")
+		tp.rejected.Add(1)
+		return nil, ErrClosed
 	default:
-		tp.metrics.IncrementTotal()
-		tp.Tasks <- task
+	}
+
+	task.handle = newTaskHandle(task.ID)
+	// A blocked task may never reach a worker, so cancelling it settles
+	// its dependents right away
+	task.handle.onCancel = func() { tp.settle(task) }
+	ready, err := tp.dag.add(task)
+	if err != nil {
+		return nil, err
+	}
+	if !ready {
+		tp.accepted()
+		return task.handle, nil
+	}
+
+	if err := tp.enqueue(ctx, task, wait); err != nil {
+		tp.dag.remove(task)
+		tp.rejected.Add(1)
+		return nil, err
+	}
+	tp.accepted()
+	return task.handle, nil
+}
+
+func (tp *TaskProcessor) enqueue(ctx context.Context, task Task, wait bool) error {
+	select {
+	case tp.Tasks <- task:
 		return nil
+	default:
+		if !wait {
+			return ErrQueueFull
+		}
+	}
+
+	select {
+	case tp.Tasks <- task:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tp.done:
+		return ErrClosed
 	}
 }
 
+func (tp *TaskProcessor) accepted() {
+	tp.submitted.Add(1)
+	tp.metrics.IncrementTotal()
+}
+
+// StartWoRetry begins the worker pool without retries. The retry
+// scheduler still runs, to release DAG dependents once they are ready.
 func (tp *TaskProcessor) StartWoRetry(ctx context.Context) {
+	tp.startScheduler(ctx)
+
 	for i := 0; i < tp.WorkerCount; i++ {
 		tp.wg.Add(1)
 		go func(workerID int) {
@@ -74,25 +191,39 @@ func (tp *TaskProcessor) StartWoRetry(ctx context.Context) {
 						return // channel closed
 					}
 
-					// Simulate per-task timeout
-					taskCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-					err := processTask(taskCtx, task)
-					cancel()
+					if task.handle.cancelled() {
+						tp.finish(task, Result{TaskID: task.ID, Error: context.Canceled})
+						continue
+					}
 
-					success := err == nil
-					tp.Results <- Result{
+					err := tp.run(ctx, task)
+					tp.finish(task, Result{
 						TaskID:  task.ID,
-						Success: success,
+						Success: err == nil,
 						Error:   err,
-					}
+					})
 				}
 			}
 		}(i)
 	}
 }
 
-// Start begins the worker pool
+// startScheduler runs the loop that feeds delayed tasks back to the
+// workers: retries, and dependents that became ready
+func (tp *TaskProcessor) startScheduler(ctx context.Context) {
+	tp.wg.Add(1)
+	go func() {
+		defer tp.wg.Done()
+		tp.retries.run(ctx, tp.done, func(task Task) bool {
+			return tp.resubmit(ctx, task)
+		})
+	}()
+}
+
+// Start begins the worker pool and the retry scheduler
 func (tp *TaskProcessor) Start(ctx context.Context) {
+	tp.startScheduler(ctx)
+
 	for i := 0; i < tp.WorkerCount; i++ {
 		tp.wg.Add(1)
 		go func(workerID int) {
@@ -108,27 +239,29 @@ func (tp *TaskProcessor) Start(ctx context.Context) {
 						return // channel closed
 					}
 
-					// Per-task timeout context
-					taskCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-					err := processTask(taskCtx, task)
-					cancel()
+					if task.handle.cancelled() {
+						tp.finish(task, Result{TaskID: task.ID, Error: context.Canceled})
+						continue
+					}
+
+					err := tp.run(ctx, task)
 
-					// Handle retry
-					if err != nil && task.Retries < 3 {
+					// Hand retries to the scheduler rather than sleeping here
+					policy := tp.policyFor(task)
+					if !task.handle.cancelled() && policy.ShouldRetry(err, task.Retries+1) {
 						task.Retries++
 						tp.metrics.IncrementRetried()
-						backoff := time.Duration(task.Retries) * 200 * time.Millisecond
-						time.Sleep(backoff)
-						tp.Tasks <- task // requeue
+						tp.retries.schedule(task, time.Now().Add(policy.Backoff(task.Retries)))
 						continue
 					}
 
+					if !tp.finish(task, Result{TaskID: task.ID, Success: err == nil, Error: err}) {
+						continue // cancelled while running
+					}
 					if err != nil {
 						tp.metrics.IncrementFailed()
-						tp.Results <- Result{TaskID: task.ID, Success: false, Error: err}
 					} else {
 						tp.metrics.IncrementSuccess()
-						tp.Results <- Result{TaskID: task.ID, Success: true}
 					}
 				}
 			}
@@ -136,12 +269,95 @@ func (tp *TaskProcessor) Start(ctx context.Context) {
 	}
 }
 
+// run processes task with a per-task timeout, stopping early if its
+// handle is cancelled
+func (tp *TaskProcessor) run(ctx context.Context, task Task) error {
+	taskCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	stop := context.AfterFunc(task.handle.ctx, cancel)
+	defer stop()
+
+	return processTask(taskCtx, task)
+}
+
+// finish resolves task's handle, settles it in the dependency graph and
+// publishes res to the subscription. It returns false if the handle was
+// already resolved, in which case nothing is published: every result is
+// delivered at most once.
+func (tp *TaskProcessor) finish(task Task, res Result) bool {
+	won := task.handle.complete(res)
+	tp.settle(task)
+	if !won {
+		return false
+	}
+	if !tp.subscribed.Load() {
+		return true
+	}
+
+	select {
+	case tp.results <- res:
+	case <-tp.done:
+		// Stopping: don't wait on a subscriber that may be gone
+		select {
+		case tp.results <- res:
+		default:
+		}
+	}
+	return true
+}
+
+func (tp *TaskProcessor) policyFor(task Task) RetryPolicy {
+	if task.Retry != nil {
+		return *task.Retry
+	}
+	return tp.Retry
+}
+
+// resubmit puts a retry back on the queue, waiting for space. It returns
+// false once the processor is stopping.
+func (tp *TaskProcessor) resubmit(ctx context.Context, task Task) bool {
+	tp.sendMu.RLock()
+	defer tp.sendMu.RUnlock()
+
+	select {
+	case <-tp.done:
+		return false
+	default:
+	}
+
+	select {
+	case tp.Tasks <- task:
+		return true
+	case <-tp.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
 // Stop gracefully shuts down the processor
 func (tp *TaskProcessor) Stop() {
-	close(tp.done)    // signal stop
-	close(tp.Tasks)   // stop accepting tasks
-	tp.wg.Wait()      // wait for workers to finish
-	close(tp.Results) // signal results done
+	close(tp.done) // signal stop; wakes any blocked Submit
+
+	tp.sendMu.Lock()
+	close(tp.Tasks) // stop accepting tasks
+	tp.sendMu.Unlock()
+
+	tp.wg.Wait() // wait for workers and the retry scheduler to finish
+
+	// Tasks still queued, waiting to retry or blocked on dependencies
+	// never finish; fail them so their handles resolve
+	leftover := tp.retries.drain()
+	for task := range tp.Tasks {
+		leftover = append(leftover, task)
+	}
+	leftover = append(leftover, tp.dag.blocked()...)
+	for _, task := range leftover {
+		if tp.finish(task, Result{TaskID: task.ID, Error: ErrClosed}) {
+			tp.metrics.IncrementFailed()
+		}
+	}
+	close(tp.results) // signal results done
 }
 
 // --------------------
@@ -211,21 +427,21 @@ func main() {
 	defer cancel()
 
 	processor := NewTaskProcessor(5)
-	go processor.Start(ctx)
+	processor.Start(ctx)
 
-	for i := 0; i < 20; i++ {
-		task := Task{
+	tasks := make([]Task, 20)
+	for i := range tasks {
+		tasks[i] = Task{
 			ID:      fmt.Sprintf("task-%d", i),
 			Payload: fmt.Sprintf("data-%d", i),
 		}
-		if err := processor.Submit(task); err != nil {
-			fmt.Printf("Submit failed: %v\n", err)
-		}
 	}
-
 	// Collect results asynchronously
+	results := processor.Subscribe()
+	collected := make(chan struct{})
 	go func() {
-		for res := range processor.Results {
+		defer close(collected)
+		for res := range results {
 			if res.Success {
 				fmt.Printf("[SUCCESS] %s processed\n", res.TaskID)
 			} else {
@@ -234,11 +450,53 @@ func main() {
 		}
 	}()
 
+	submitCtx, cancelSubmit := context.WithTimeout(ctx, time.Second)
+	handles, errs := processor.SubmitBatch(submitCtx, tasks)
+	cancelSubmit()
+	for i, err := range errs {
+		if err != nil {
+			fmt.Printf("Submit %s failed: %v\n", tasks[i].ID, err)
+		}
+	}
+
+	// A multi-step job: upload waits on transform, which waits on fetch
+	steps := []Task{
+		{ID: "upload", Payload: "s3://bucket/out", DependsOn: []string{"transform"}},
+		{ID: "transform", Payload: "resize", DependsOn: []string{"fetch"}},
+		{ID: "fetch", Payload: "s3://bucket/in"},
+	}
+	for _, step := range steps {
+		if _, err := processor.Submit(ctx, step); err != nil {
+			fmt.Printf("Submit %s failed: %v\n", step.ID, err)
+		}
+	}
+	if _, err := processor.Submit(ctx, Task{ID: "fetch-retry", DependsOn: []string{"upload", "fetch-retry"}}); err != nil {
+		fmt.Printf("Submit fetch-retry failed: %v\n", err)
+	}
+
+	// Cancel one task and wait on another directly
+	if h := handles[len(handles)-1]; h != nil {
+		h.Cancel()
+		res, _ := h.Wait(ctx)
+		fmt.Printf("[CANCELLED] %s: %v\n", res.TaskID, res.Error)
+	}
+	if h := handles[0]; h != nil {
+		if res, err := h.Wait(ctx); err == nil {
+			fmt.Printf("[WAITED] %s success=%v\n", res.TaskID, res.Success)
+		}
+	}
+
 	time.Sleep(5 * time.Second)
+	upload, _ := processor.TaskState("upload")
+	fmt.Printf("DAG: %v (upload %s)\n", processor.DAGStatus(), upload)
 	processor.Stop()
+	<-collected
 
 	total, success, failed, retried := processor.metrics.GetStats()
 	fmt.Printf("\n--- METRICS ---\nTotal: %d | Success: %d | Failed: %d | Retried: %d\n",
 		total, success, failed, retried)
+	stats := processor.Stats()
+	fmt.Printf("Queue: %d/%d | Submitted: %d | Rejected: %d\n",
+		stats.Depth, stats.Capacity, stats.Submitted, stats.Rejected)
 	fmt.Println("All tasks processed")
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// --------------------
+// RetryPolicy
+// --------------------
+
+// RetryPolicy decides whether and when a failed task runs again
+type RetryPolicy struct {
+	MaxAttempts int           // total runs including the first; 1 disables retries
+	BaseDelay   time.Duration // delay before the first retry
+	MaxDelay    time.Duration // cap on any single delay; 0 for none
+	Multiplier  float64       // growth per retry; below 1 is treated as 2
+	Jitter      float64       // randomize each delay by up to this fraction, 0-1
+	Retryable   func(error) bool
+}
+
+// DefaultRetryPolicy retries up to 3 times at roughly 200ms, 400ms, 800ms
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   200 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Multiplier:  2,
+		Jitter:      0.2,
+	}
+}
+
+// ShouldRetry reports whether a task that has run attempts times and
+// failed with err gets another run. Without a Retryable predicate every
+// error except cancellation is retried.
+func (p RetryPolicy) ShouldRetry(err error, attempts int) bool {
+	if err == nil || attempts >= p.MaxAttempts {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// Backoff is the delay before retry number n (1-based)
+func (p RetryPolicy) Backoff(n int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+
+	delay := float64(p.BaseDelay)
+	for i := 1; i < n; i++ {
+		delay *= mult
+		if p.MaxDelay > 0 && delay >= float64(p.MaxDelay) {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UserClient sits in front of the user API. Users are cached for a TTL,
+// concurrent Gets for the same ID share one fetch, and in batch mode
+// Gets issued within a short window are sent as one batch call
+// (the dataloader pattern). Failures are not cached.
+type UserClient struct {
+	fetch     func(context.Context, int) (*UserData, error)
+	batch     func(context.Context, []int) ([]*UserData, []error)
+	ttl       time.Duration
+	batchWait time.Duration
+	maxBatch  int
+
+	mu       sync.Mutex
+	cache    map[int]cachedUser
+	inflight map[int]*userCall
+	pending  *userBatch // collecting IDs, not yet sent
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	coalesced atomic.Int64
+	loads     atomic.Int64
+}
+
+type cachedUser struct {
+	user    *UserData
+	expires time.Time
+}
+
+// userCall is one fetch of one ID, shared by every Get waiting on it
+type userCall struct {
+	done chan struct{}
+	user *UserData
+	err  error
+
+	waiters int    // guarded by UserClient.mu
+	abandon func() // called under UserClient.mu once waiters drops to 0
+}
+
+// userBatch collects IDs for one batch call
+type userBatch struct {
+	ids    []int
+	calls  []*userCall
+	index  map[int]int // id -> position in ids
+	timer  *time.Timer
+	ctx    context.Context
+	cancel context.CancelFunc
+	live   int // calls someone still waits on, guarded by UserClient.mu
+}
+
+// UserClientStats counts Gets by how they were served
+type UserClientStats struct {
+	Hits      int64 // from the cache
+	Misses    int64 // started a fetch
+	Coalesced int64 // joined a fetch already in flight
+	Loads     int64 // calls made to the API, single or batch
+}
+
+// NewUserClient caches the results of fetch for ttl and coalesces
+// concurrent fetches of the same ID
+func NewUserClient(fetch func(context.Context, int) (*UserData, error), ttl time.Duration) *UserClient {
+	return &UserClient{
+		fetch:    fetch,
+		ttl:      ttl,
+		cache:    make(map[int]cachedUser),
+		inflight: make(map[int]*userCall),
+	}
+}
+
+// NewBatchUserClient is NewUserClient over a batch endpoint. A Get that
+// misses the cache waits up to wait for others to join its batch; a batch
+// is sent early once it holds maxBatch IDs.
+func NewBatchUserClient(batch func(context.Context, []int) ([]*UserData, []error), ttl, wait time.Duration, maxBatch int) *UserClient {
+	c := NewUserClient(nil, ttl)
+	c.batch = batch
+	c.batchWait = wait
+	c.maxBatch = max(maxBatch, 1)
+	return c
+}
+
+// Get returns the user from the cache, or fetches it. Giving up on ctx
+// only cancels the fetch once no other Get is waiting on it.
+func (c *UserClient) Get(ctx context.Context, id int) (*UserData, error) {
+	c.mu.Lock()
+	if user, ok := c.cachedLocked(id); ok {
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return user, nil
+	}
+	call, ok := c.inflight[id]
+	if ok {
+		c.coalesced.Add(1)
+	} else {
+		c.misses.Add(1)
+		call = c.startLocked(ctx, id)
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.user, call.err
+	case <-ctx.Done():
+		c.leave(id, call)
+		return nil, ctx.Err()
+	}
+}
+
+// FetchAll is fetchAllUsers through the cache: cached users are returned
+// without counting against the rate limit, the rest are fetched per opts
+func (c *UserClient) FetchAll(ctx context.Context, userIDs []int, opts FetchOptions) (*BatchFetchResult, error) {
+	cached := make(map[int]*UserData)
+	var missing []int
+
+	c.mu.Lock()
+	for _, id := range userIDs {
+		if user, ok := c.cachedLocked(id); ok {
+			cached[id] = user
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	c.mu.Unlock()
+	c.hits.Add(int64(len(cached)))
+
+	result, err := FetchAll(ctx, missing, c.Get, opts)
+	result.keys = userIDs
+	for id, user := range cached {
+		result.Values[id] = user
+		result.Attempts[id] = 0
+	}
+
+	var fetchErr *FetchError[int]
+	if errors.As(err, &fetchErr) {
+		fetchErr.Total = len(userIDs)
+	}
+	return result, err
+}
+
+// Invalidate drops id from the cache; a fetch in flight is unaffected
+func (c *UserClient) Invalidate(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cache, id)
+}
+
+func (c *UserClient) Stats() UserClientStats {
+	return UserClientStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Coalesced: c.coalesced.Load(),
+		Loads:     c.loads.Load(),
+	}
+}
+
+// cachedLocked returns the cached user unless it has expired, dropping
+// it if so
+func (c *UserClient) cachedLocked(id int) (*UserData, bool) {
+	e, ok := c.cache[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.cache, id)
+		return nil, false
+	}
+	return e.user, true
+}
+
+// startLocked begins fetching id, alone or as part of the pending batch.
+// The fetch keeps ctx's values but not its cancellation, which belongs to
+// the caller alone; it is cancelled once every waiter has left.
+func (c *UserClient) startLocked(ctx context.Context, id int) *userCall {
+	call := &userCall{done: make(chan struct{})}
+	c.inflight[id] = call
+
+	if c.batch != nil {
+		c.enqueueLocked(ctx, id, call)
+		return call
+	}
+
+	fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	call.abandon = cancel
+	go func() {
+		defer cancel()
+		c.loads.Add(1)
+		user, err := c.fetch(fetchCtx, id)
+		c.complete(id, call, user, err)
+	}()
+	return call
+}
+
+// leave drops a waiter that gave up, abandoning the fetch if it was the
+// last. The call is forgotten, so a later Get starts a fresh one.
+func (c *UserClient) leave(id int, call *userCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if call.waiters--; call.waiters > 0 {
+		return
+	}
+	if c.inflight[id] == call {
+		delete(c.inflight, id)
+		call.abandon()
+	}
+}
+
+// complete resolves call and caches a successful result
+func (c *UserClient) complete(id int, call *userCall, user *UserData, err error) {
+	c.mu.Lock()
+	if c.inflight[id] == call {
+		delete(c.inflight, id)
+	}
+	if err == nil && c.ttl > 0 {
+		c.cache[id] = cachedUser{user: user, expires: time.Now().Add(c.ttl)}
+	}
+	c.mu.Unlock()
+
+	call.user, call.err = user, err
+	close(call.done)
+}
+
+// --------------------
+// Batch loading
+// --------------------
+
+// enqueueLocked adds id to the pending batch, starting one if needed.
+// The batch is sent when its window closes or it fills up.
+func (c *UserClient) enqueueLocked(ctx context.Context, id int, call *userCall) {
+	b := c.pending
+	if b == nil {
+		bctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		b = &userBatch{ctx: bctx, cancel: cancel, index: make(map[int]int)}
+		b.timer = time.AfterFunc(c.batchWait, func() { c.dispatch(b) })
+		c.pending = b
+	}
+
+	if i, ok := b.index[id]; ok {
+		// id was abandoned earlier in this window; its slot serves the
+		// new call instead of asking for the ID twice
+		b.calls[i] = call
+	} else {
+		b.index[id] = len(b.ids)
+		b.ids = append(b.ids, id)
+		b.calls = append(b.calls, call)
+	}
+	b.live++
+	call.abandon = func() {
+		// The batch is only cancelled once nobody waits on any of its IDs.
+		// A cancelled batch still collecting is dropped, so later Gets
+		// start a fresh one rather than joining a dead one.
+		if b.live--; b.live > 0 {
+			return
+		}
+		if c.pending == b {
+			b.timer.Stop()
+			c.pending = nil
+		}
+		b.cancel()
+	}
+
+	if len(b.ids) >= c.maxBatch {
+		b.timer.Stop()
+		c.pending = nil
+		go c.send(b)
+	}
+}
+
+// dispatch sends b when its window closes, unless it already went out
+// full
+func (c *UserClient) dispatch(b *userBatch) {
+	c.mu.Lock()
+	if c.pending != b {
+		c.mu.Unlock()
+		return
+	}
+	c.pending = nil
+	c.mu.Unlock()
+	c.send(b)
+}
+
+func (c *UserClient) send(b *userBatch) {
+	defer b.cancel()
+	c.loads.Add(1)
+
+	users, errs := c.batch(b.ctx, b.ids)
+	for i, id := range b.ids {
+		var user *UserData
+		var err error
+		if i < len(users) {
+			user = users[i]
+		}
+		if i < len(errs) {
+			err = errs[i]
+		}
+		if user == nil && err == nil {
+			err = fmt.Errorf("user %d missing from batch response", id)
+		}
+		c.complete(id, b.calls[i], user, err)
+	}
+}
+
+// UserCacheDemo fetches overlapping ID ranges through a caching client,
+// then issues scattered concurrent Gets through a batching one
+func UserCacheDemo() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	opts := DefaultFetchOptions(10)
+	opts.MaxConcurrency = 4
+
+	client := NewUserClient(fetchUserData, time.Minute)
+	ids := func(from, to int) []int {
+		out := make([]int, 0, to-from+1)
+		for id := from; id <= to; id++ {
+			out = append(out, id)
+		}
+		return out
+	}
+	for _, r := range [][2]int{{1, 30}, {20, 50}, {1, 50}} {
+		start := time.Now()
+		res, _ := client.FetchAll(ctx, ids(r[0], r[1]), opts)
+		fmt.Printf("Users %d-%d: %d fetched, %d failed in %v\n",
+			r[0], r[1], len(res.Values), len(res.Errors), time.Since(start).Round(time.Millisecond))
+	}
+	fmt.Printf("Cache: %+v\n", client.Stats())
+
+	// Independent callers, several wanting the same user
+	loader := NewBatchUserClient(fetchUserDataBatch, time.Minute, 10*time.Millisecond, 25)
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+	start := time.Now()
+	for i := range 100 {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if _, err := loader.Get(ctx, id); err != nil {
+				failed.Add(1)
+			}
+		}(i%60 + 1)
+	}
+	wg.Wait()
+	fmt.Printf("Batch loader: 100 Gets, %d failed in %v, %+v\n",
+		failed.Load(), time.Since(start).Round(time.Millisecond), loader.Stats())
+}
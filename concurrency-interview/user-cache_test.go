@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cancelled is a context that is already done
+func cancelled() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestUserClientCoalescesConcurrentGets(t *testing.T) {
+	var fetches atomic.Int64
+	release := make(chan struct{})
+	client := NewUserClient(func(ctx context.Context, id int) (*UserData, error) {
+		fetches.Add(1)
+		<-release
+		return &UserData{ID: id}, nil
+	}, time.Minute)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if u, err := client.Get(context.Background(), 7); err != nil || u.ID != 7 {
+				t.Errorf("Get = %v, %v", u, err)
+			}
+		}()
+	}
+	// Let every Get join before the fetch returns
+	for client.Stats().Misses+client.Stats().Coalesced < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if _, err := client.Get(context.Background(), 7); err != nil {
+		t.Fatal(err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("%d fetches, want 1", n)
+	}
+	if st := client.Stats(); st.Hits != 1 || st.Coalesced != 9 {
+		t.Errorf("stats %+v, want 1 hit and 9 coalesced", st)
+	}
+}
+
+func TestUserClientFetchOutlivesOneCaller(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var fetchErr atomic.Value
+	client := NewUserClient(func(ctx context.Context, id int) (*UserData, error) {
+		close(started)
+		select {
+		case <-release:
+			return &UserData{ID: id}, nil
+		case <-ctx.Done():
+			fetchErr.Store(ctx.Err())
+			return nil, ctx.Err()
+		}
+	}, time.Minute)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.Get(context.Background(), 1)
+		result <- err
+	}()
+	<-started
+
+	// A second caller giving up must not cancel the first caller's fetch
+	short, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.Get(short, 1); err == nil {
+		t.Fatal("Get with an expired context succeeded")
+	}
+	close(release)
+
+	if err := <-result; err != nil {
+		t.Fatalf("patient caller got %v", err)
+	}
+	if err := fetchErr.Load(); err != nil {
+		t.Fatalf("shared fetch was cancelled: %v", err)
+	}
+}
+
+func TestUserClientAbandonedFetchIsCancelled(t *testing.T) {
+	cancelledFetch := make(chan struct{})
+	client := NewUserClient(func(ctx context.Context, id int) (*UserData, error) {
+		<-ctx.Done()
+		close(cancelledFetch)
+		return nil, ctx.Err()
+	}, time.Minute)
+
+	if _, err := client.Get(cancelled(), 1); err != context.Canceled {
+		t.Fatalf("Get = %v, want context.Canceled", err)
+	}
+	select {
+	case <-cancelledFetch:
+	case <-time.After(time.Second):
+		t.Fatal("fetch not cancelled after its only caller left")
+	}
+}
+
+// recordingBatch serves every ID and records the batches it was asked for
+type recordingBatch struct {
+	mu      sync.Mutex
+	batches [][]int
+}
+
+func (r *recordingBatch) fetch(ctx context.Context, ids []int) ([]*UserData, []error) {
+	r.mu.Lock()
+	r.batches = append(r.batches, slices.Clone(ids))
+	r.mu.Unlock()
+
+	users := make([]*UserData, len(ids))
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		users[i] = &UserData{ID: id, Name: fmt.Sprintf("User%d", id)}
+	}
+	return users, errs
+}
+
+func (r *recordingBatch) calls() [][]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.batches)
+}
+
+func TestBatchLoaderGroupsGets(t *testing.T) {
+	var backend recordingBatch
+	loader := NewBatchUserClient(backend.fetch, time.Minute, 20*time.Millisecond, 100)
+
+	var wg sync.WaitGroup
+	for i := range 40 {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if u, err := loader.Get(context.Background(), id); err != nil || u.ID != id {
+				t.Errorf("Get(%d) = %v, %v", id, u, err)
+			}
+		}(i%20 + 1)
+	}
+	wg.Wait()
+
+	batches := backend.calls()
+	if len(batches) != 1 || len(batches[0]) != 20 {
+		t.Fatalf("batches %v, want one batch of the 20 distinct IDs", batches)
+	}
+}
+
+func TestBatchLoaderAbandonedBatchDoesNotFailLaterGets(t *testing.T) {
+	var backend recordingBatch
+	loader := NewBatchUserClient(backend.fetch, time.Minute, 20*time.Millisecond, 100)
+
+	// The only caller of the pending batch leaves, cancelling it
+	if _, err := loader.Get(cancelled(), 1); err != context.Canceled {
+		t.Fatalf("Get = %v, want context.Canceled", err)
+	}
+
+	// A Get within the same window must not join the dead batch
+	u, err := loader.Get(context.Background(), 2)
+	if err != nil || u.ID != 2 {
+		t.Fatalf("Get(2) = %v, %v", u, err)
+	}
+	if batches := backend.calls(); len(batches) != 1 || !slices.Equal(batches[0], []int{2}) {
+		t.Errorf("batches %v, want just [2]", batches)
+	}
+}
+
+func TestBatchLoaderDedupesAbandonedIDs(t *testing.T) {
+	var backend recordingBatch
+	loader := NewBatchUserClient(backend.fetch, time.Minute, 20*time.Millisecond, 100)
+
+	// Keep the batch alive with another waiter
+	other := make(chan error, 1)
+	go func() {
+		_, err := loader.Get(context.Background(), 2)
+		other <- err
+	}()
+	for loader.Stats().Misses < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 1 is requested, abandoned and requested again in the same window
+	if _, err := loader.Get(cancelled(), 1); err != context.Canceled {
+		t.Fatalf("Get = %v, want context.Canceled", err)
+	}
+	u, err := loader.Get(context.Background(), 1)
+	if err != nil || u.ID != 1 {
+		t.Fatalf("Get(1) = %v, %v", u, err)
+	}
+	if err := <-other; err != nil {
+		t.Fatalf("Get(2) = %v", err)
+	}
+
+	batches := backend.calls()
+	if len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("batches %v, want one batch of [2 1]", batches)
+	}
+}